@@ -2,7 +2,11 @@ module teleport-go-watcher
 
 go 1.25.0
 
-require github.com/gravitational/teleport/api v0.0.0-20250815185246-582bbb68c99f
+require (
+	github.com/gravitational/teleport/api v0.0.0-20250815185246-582bbb68c99f
+	github.com/gravitational/trace v1.5.1
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
 	github.com/beevik/etree v1.6.0 // indirect
@@ -19,7 +23,6 @@ require (
 	github.com/gobwas/ws v1.4.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/gravitational/trace v1.5.1 // indirect
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.27.1 // indirect
 	github.com/jonboulle/clockwork v0.5.0 // indirect
 	github.com/mattermost/xml-roundtrip-validator v0.1.0 // indirect
@@ -44,5 +47,4 @@ require (
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20260120221211-b8f7ae30c516 // indirect
 	google.golang.org/grpc v1.80.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -1,32 +1,276 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gravitational/teleport/api/client"
+	"github.com/gravitational/teleport/api/client/proto"
 	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
 )
 
-// Config holds the application configuration
+// Config holds the application configuration. It can be loaded from a YAML
+// file (keys are the yaml tags), JIT_WATCHER_* environment variables (the
+// upper-cased yaml tags) and command line flags.
 type Config struct {
-	ProxyServer       string
-	IdentityFile     string
-	MaxResources     int
-	CheckResources   bool
-	CheckConflicts   bool
-	Debug            bool
-	PollInterval     time.Duration
-	ConflictPatterns []string  // Configurable patterns for role conflict checking
+	ProxyServer      string        `yaml:"proxy_server"`
+	IdentityFile     string        `yaml:"identity_file"`
+	MaxResources     int           `yaml:"max_resources"`
+	CheckResources   bool          `yaml:"check_resources"`
+	CheckConflicts   bool          `yaml:"check_conflicts"`
+	Debug            bool          `yaml:"debug"`
+	PollInterval     time.Duration `yaml:"poll_interval"`
+	ConflictPatterns []string      `yaml:"conflict_patterns"` // Configurable patterns for role conflict checking
+	Workers          int           `yaml:"workers"`           // Number of concurrent enforcement workers
+	MaxRetries       int           `yaml:"max_retries"`       // Retries per enforcement call before giving up until the next poll
+	RetryBackoff     time.Duration `yaml:"retry_backoff"`     // Initial retry delay, doubled on every attempt
+	PageSize         int           `yaml:"page_size"`         // Access requests fetched per ListAccessRequests call
+	ConflictStrategy string        `yaml:"conflict_strategy"` // Which requests survive a policy violation (see Strategy* constants)
+	PriorityLabel    string        `yaml:"priority_label"`    // Role label holding an integer priority for keep-highest-priority
+	NotifyWebhook    string        `yaml:"notify_webhook"`    // Optional URL that receives lock-all-and-notify notifications
+
+	// Separation-of-duties checks on the reviewers of approved requests
+	CheckSeparationOfDuties bool   `yaml:"check_separation_of_duties"` // Enable separation-of-duties checking
+	SoDTeamTrait            string `yaml:"sod_team_trait"`             // User trait holding the team name
+	SoDProdPattern          string `yaml:"sod_prod_pattern"`           // Pattern matching production roles
+	SoDMaxDailyApprovals    int    `yaml:"sod_max_daily_approvals"`    // Max approvals by one reviewer for one user in 24h (0 disables)
+
+	// Health endpoints and connection watchdog
+	HealthAddr        string        `yaml:"health_addr"`        // Listen address for /healthz and /readyz (empty disables)
+	WatchdogThreshold time.Duration `yaml:"watchdog_threshold"` // Act when no evaluation succeeded for this long (0 disables)
+	WatchdogAction    string        `yaml:"watchdog_action"`    // "exit" or "reconnect"
+}
+
+// envPrefix prefixes the environment variable of every Config field
+const envPrefix = "JIT_WATCHER_"
+
+// defaultConfig returns the configuration used when nothing else is set
+func defaultConfig() Config {
+	return Config{
+		MaxResources:         3,
+		CheckResources:       true,
+		CheckConflicts:       true,
+		PollInterval:         30 * time.Second,
+		ConflictPatterns:     []string{"prod", "research"},
+		Workers:              4,
+		MaxRetries:           3,
+		RetryBackoff:         time.Second,
+		PageSize:             100,
+		ConflictStrategy:     StrategyKeepNewest,
+		PriorityLabel:        "jit-watcher/priority",
+		SoDTeamTrait:         "team",
+		SoDProdPattern:       "prod",
+		SoDMaxDailyApprovals: 5,
+		WatchdogAction:       WatchdogExit,
+	}
+}
+
+// loadConfigFile overlays the settings from a YAML file onto config. Unknown
+// keys are rejected so that typos do not go unnoticed.
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overlays JIT_WATCHER_* environment variables onto config. Lists
+// are comma-separated, durations use Go syntax (e.g. 30s).
+func applyEnv(config *Config) error {
+	durationType := reflect.TypeOf(time.Duration(0))
+	value := reflect.ValueOf(config).Elem()
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := envPrefix + strings.ToUpper(field.Tag.Get("yaml"))
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		target := value.Field(i)
+		switch {
+		case field.Type == durationType:
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return fmt.Errorf("invalid duration in %s: %w", name, err)
+			}
+			target.SetInt(int64(d))
+		case field.Type.Kind() == reflect.String:
+			target.SetString(raw)
+		case field.Type.Kind() == reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("invalid integer in %s: %w", name, err)
+			}
+			target.SetInt(int64(n))
+		case field.Type.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("invalid boolean in %s: %w", name, err)
+			}
+			target.SetBool(b)
+		case field.Type.Kind() == reflect.Slice:
+			var list StringSliceFlag
+			list.Set(raw)
+			target.Set(reflect.ValueOf([]string(list)))
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration before the watcher starts
+func (c Config) Validate() error {
+	// Validate required settings
+	if c.ProxyServer == "" {
+		return fmt.Errorf("proxy service is required (-p, proxy_server or %sPROXY_SERVER)", envPrefix)
+	}
+	if c.IdentityFile == "" {
+		return fmt.Errorf("identity file is required (-i, identity_file or %sIDENTITY_FILE)", envPrefix)
+	}
+
+	// Validate identity file exists
+	if _, err := os.Stat(c.IdentityFile); os.IsNotExist(err) {
+		return fmt.Errorf("identity file does not exist: %s", c.IdentityFile)
+	}
+
+	// Validate max resources
+	if c.MaxResources < 1 {
+		return fmt.Errorf("max resources must be a positive integer, got: %d", c.MaxResources)
+	}
+
+	// Validate poll interval
+	if c.PollInterval < time.Second {
+		return fmt.Errorf("poll interval must be at least 1 second, got: %s", c.PollInterval)
+	}
+
+	// Validate enforcement queue settings
+	if c.Workers < 1 {
+		return fmt.Errorf("workers must be a positive integer, got: %d", c.Workers)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("max retries must be zero or greater, got: %d", c.MaxRetries)
+	}
+	if c.RetryBackoff <= 0 {
+		return fmt.Errorf("retry backoff must be positive, got: %s", c.RetryBackoff)
+	}
+
+	// Validate page size
+	if c.PageSize < 1 {
+		return fmt.Errorf("page size must be a positive integer, got: %d", c.PageSize)
+	}
+
+	// Validate separation-of-duties settings
+	if c.SoDMaxDailyApprovals < 0 {
+		return fmt.Errorf("max daily approvals must be zero or greater, got: %d", c.SoDMaxDailyApprovals)
+	}
+	if _, err := regexp.Compile(c.SoDProdPattern); err != nil {
+		return fmt.Errorf("invalid separation-of-duties production pattern '%s': %w", c.SoDProdPattern, err)
+	}
+
+	// Validate watchdog settings
+	if c.WatchdogThreshold < 0 {
+		return fmt.Errorf("watchdog threshold must be zero or greater, got: %s", c.WatchdogThreshold)
+	}
+	if c.WatchdogThreshold > 0 && c.WatchdogThreshold < c.PollInterval {
+		return fmt.Errorf("watchdog threshold must be at least the poll interval (%s), got: %s", c.PollInterval, c.WatchdogThreshold)
+	}
+	if c.WatchdogAction != WatchdogExit && c.WatchdogAction != WatchdogReconnect {
+		return fmt.Errorf("watchdog action must be %s or %s, got: %s", WatchdogExit, WatchdogReconnect, c.WatchdogAction)
+	}
+
+	// Validate conflict strategy
+	validStrategy := false
+	for _, strategy := range conflictStrategies {
+		if c.ConflictStrategy == strategy {
+			validStrategy = true
+			break
+		}
+	}
+	if !validStrategy {
+		return fmt.Errorf("conflict strategy must be one of %s, got: %s", strings.Join(conflictStrategies, ", "), c.ConflictStrategy)
+	}
+
+	// Validate conflict patterns
+	if c.CheckConflicts && len(c.ConflictPatterns) < 2 {
+		return fmt.Errorf("role conflict checking requires at least 2 patterns, got: %v", c.ConflictPatterns)
+	}
+	for _, pattern := range c.ConflictPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid conflict pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Watchdog actions taken when evaluations keep failing
+const (
+	WatchdogExit      = "exit"
+	WatchdogReconnect = "reconnect"
+)
+
+// apiCallTimeout bounds each enforcement attempt and connectivity check, so
+// that a call hung on a broken connection cannot stall a pass
+const apiCallTimeout = 30 * time.Second
+
+// Conflict resolution strategies decide which of a user's approved requests
+// stay unlocked when they conflict or exceed the resource limit
+const (
+	StrategyKeepNewest          = "keep-newest"
+	StrategyKeepOldest          = "keep-oldest"
+	StrategyKeepHighestPriority = "keep-highest-priority"
+	StrategyKeepSmallest        = "keep-smallest"
+	StrategyLockAllAndNotify    = "lock-all-and-notify"
+)
+
+// Policy IDs recorded on every decision the watcher makes
+const (
+	PolicyAutoApprove         = "auto-approve"
+	PolicyResourceLimit       = "resource-limit"
+	PolicyEnvironmentConflict = "environment-conflict"
+	PolicySeparationOfDuties  = "separation-of-duties"
+)
+
+// Keys of the annotations written on resolved access requests and of the
+// labels written on the locks the watcher creates
+const (
+	annotationPolicyID      = "jit-watcher/policy-id"
+	annotationPolicyVersion = "jit-watcher/policy-version"
+	annotationEvaluatedAt   = "jit-watcher/evaluated-at"
+)
+
+// conflictStrategies lists the valid values for Config.ConflictStrategy
+var conflictStrategies = []string{
+	StrategyKeepNewest,
+	StrategyKeepOldest,
+	StrategyKeepHighestPriority,
+	StrategyKeepSmallest,
+	StrategyLockAllAndNotify,
 }
 
 // Watcher manages the access request monitoring
@@ -35,6 +279,89 @@ type Watcher struct {
 	client          *client.Client
 	lockedRequests  map[string]bool
 	conflictPatterns []*regexp.Regexp  // Compiled regex patterns for conflict detection
+
+	mu           sync.Mutex                // Guards lockedRequests, inFlight and requestCache
+	tasks        chan *enforcementTask     // Work queue consumed by the enforcement workers
+	inFlight     map[string]bool           // Idempotency keys of queued or running tasks
+	pending      sync.WaitGroup            // Outstanding tasks for the current evaluation pass
+	requestCache map[string]*cachedRequest // Last seen version of each listed request, by request ID
+
+	rolePriorities map[string]int // Role priorities looked up during the current pass
+
+	policyVersions map[string]string     // Version of each policy, derived from its settings
+	evaluatedAt    time.Time             // Start of the current evaluation pass
+
+	sodProdPattern *regexp.Regexp        // Compiled SoDProdPattern
+	users          map[string]types.User // Reviewers and requesters looked up during the current pass
+
+	health healthState // Evaluation and connectivity status for the health endpoints and watchdog
+}
+
+// healthState tracks the outcome of evaluation passes and the Teleport
+// connection. It is read concurrently by the health endpoints.
+type healthState struct {
+	mu                  sync.Mutex
+	started             time.Time // When the watcher started
+	lastSuccess         time.Time // End of the last successful evaluation pass
+	lastReconnect       time.Time // Last time the watchdog reconnected
+	lastError           string    // Error of the last failed pass
+	consecutiveFailures int       // Failed passes since the last success
+	connected           bool      // Result of the last connectivity check
+
+	cancelPass   context.CancelFunc // Cancels the running evaluation pass, if any
+	reconnectDue bool               // The watchdog asked for a new client; the poll loop swaps it between passes
+}
+
+// healthStatus is the JSON body returned by the health endpoints
+type healthStatus struct {
+	Status              string `json:"status"`
+	Connected           bool   `json:"connected"`
+	LastSuccess         string `json:"last_success,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// cachedRequest is the last seen version of an access request
+type cachedRequest struct {
+	revision string
+	user     string
+}
+
+// policyDecision records which policy made a decision and when it was
+// evaluated. It is written to the access request as annotations, or to the
+// lock as labels.
+type policyDecision struct {
+	PolicyID    string
+	Version     string
+	EvaluatedAt time.Time
+}
+
+// annotations returns the decision as access request annotations
+func (d policyDecision) annotations() map[string][]string {
+	return map[string][]string{
+		annotationPolicyID:      {d.PolicyID},
+		annotationPolicyVersion: {d.Version},
+		annotationEvaluatedAt:   {d.EvaluatedAt.Format(time.RFC3339)},
+	}
+}
+
+// labels returns the decision as resource labels
+func (d policyDecision) labels() map[string]string {
+	return map[string]string{
+		annotationPolicyID:      d.PolicyID,
+		annotationPolicyVersion: d.Version,
+		annotationEvaluatedAt:   d.EvaluatedAt.Format(time.RFC3339),
+	}
+}
+
+// enforcementTask is a single Teleport API call (approve, deny or lock) queued
+// for the enforcement workers
+type enforcementTask struct {
+	key    string                          // Idempotency key, one per action and request ID
+	desc   string                          // Human-readable description for logging
+	run    func(ctx context.Context) error // The API call to perform
+	onDone func(err error)                 // Called once with the final result
+	ctx    context.Context                 // Context of the pass that queued the task, set by enqueue
 }
 
 // AccessRequestInfo holds parsed information about an access request
@@ -45,15 +372,17 @@ type AccessRequestInfo struct {
 	Resources    []types.ResourceID
 	Created      time.Time
 	State        types.RequestState
+	Revision     string
+	Reviews      []types.AccessReview
 }
 
-// NewWatcher creates a new Watcher instance
-func NewWatcher(config Config) (*Watcher, error) {
+// newClient creates a Teleport client from the Machine ID identity file
+func newClient(ctx context.Context, config Config) (*client.Client, error) {
 	// Load Machine ID identity file
 	creds := client.LoadIdentityFile(config.IdentityFile)
 
 	// Create Teleport client
-	teleportClient, err := client.New(context.Background(), client.Config{
+	teleportClient, err := client.New(ctx, client.Config{
 		Addrs:       []string{config.ProxyServer},
 		Credentials: []client.Credentials{creds},
 	})
@@ -61,6 +390,16 @@ func NewWatcher(config Config) (*Watcher, error) {
 		return nil, fmt.Errorf("failed to create teleport client: %w", err)
 	}
 
+	return teleportClient, nil
+}
+
+// NewWatcher creates a new Watcher instance
+func NewWatcher(config Config) (*Watcher, error) {
+	teleportClient, err := newClient(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+
 	// Compile conflict patterns
 	var conflictPatterns []*regexp.Regexp
 	for _, pattern := range config.ConflictPatterns {
@@ -71,14 +410,58 @@ func NewWatcher(config Config) (*Watcher, error) {
 		conflictPatterns = append(conflictPatterns, re)
 	}
 
+	// Compile separation-of-duties production pattern
+	sodProdPattern, err := regexp.Compile(`(?i)` + config.SoDProdPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile pattern '%s': %w", config.SoDProdPattern, err)
+	}
+
 	return &Watcher{
 		config:          config,
+		policyVersions:  policyVersions(config),
 		client:          teleportClient,
 		lockedRequests:  make(map[string]bool),
 		conflictPatterns: conflictPatterns,
+		tasks:           make(chan *enforcementTask, config.Workers),
+		inFlight:        make(map[string]bool),
+		requestCache:    make(map[string]*cachedRequest),
+		sodProdPattern:  sodProdPattern,
+		health:          healthState{started: time.Now()},
 	}, nil
 }
 
+// policyVersions derives a version for each policy from the settings that
+// affect its decisions, so a decision can be traced back to the configuration
+// that produced it
+func policyVersions(config Config) map[string]string {
+	settings := map[string]string{
+		PolicyAutoApprove: fmt.Sprintf("resources=%t/%d;conflicts=%t/%s",
+			config.CheckResources, config.MaxResources, config.CheckConflicts, strings.Join(config.ConflictPatterns, ",")),
+		PolicyResourceLimit: fmt.Sprintf("max=%d;strategy=%s;priority-label=%s",
+			config.MaxResources, config.ConflictStrategy, config.PriorityLabel),
+		PolicyEnvironmentConflict: fmt.Sprintf("patterns=%s;strategy=%s;priority-label=%s",
+			strings.Join(config.ConflictPatterns, ","), config.ConflictStrategy, config.PriorityLabel),
+		PolicySeparationOfDuties: fmt.Sprintf("team-trait=%s;prod=%s;max-daily=%d",
+			config.SoDTeamTrait, config.SoDProdPattern, config.SoDMaxDailyApprovals),
+	}
+
+	versions := make(map[string]string, len(settings))
+	for id, setting := range settings {
+		sum := sha256.Sum256([]byte(setting))
+		versions[id] = hex.EncodeToString(sum[:])[:12]
+	}
+	return versions
+}
+
+// decision returns the decision record for a policy in the current pass
+func (w *Watcher) decision(policyID string) policyDecision {
+	return policyDecision{
+		PolicyID:    policyID,
+		Version:     w.policyVersions[policyID],
+		EvaluatedAt: w.evaluatedAt,
+	}
+}
+
 // Close cleans up the watcher
 func (w *Watcher) Close() {
 	w.client.Close()
@@ -111,30 +494,101 @@ func (w *Watcher) parseAccessRequest(req types.AccessRequest) *AccessRequestInfo
 		Resources: req.GetRequestedResourceIDs(),
 		Created:   req.GetCreationTime(),
 		State:     req.GetState(),
+		Revision:  req.GetRevision(),
+		Reviews:   req.GetReviews(),
 	}
 }
 
-// getAllAccessRequests fetches all access requests
-func (w *Watcher) getAllAccessRequests(ctx context.Context) ([]*AccessRequestInfo, error) {
-	w.logDebug("Fetching all access requests")
+// listAccessRequests pages through the access requests in the given state.
+// Approved requests whose access has already expired are skipped.
+func (w *Watcher) listAccessRequests(ctx context.Context, state types.RequestState) ([]*AccessRequestInfo, error) {
+	var parsed []*AccessRequestInfo
+	now := time.Now()
+	startKey := ""
 
-	// Get all access requests
-	filter := types.AccessRequestFilter{}
-	requests, err := w.client.GetAccessRequests(ctx, filter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get access requests: %w", err)
+	for {
+		resp, err := w.client.ListAccessRequests(ctx, &proto.ListAccessRequestsRequest{
+			Filter:   &types.AccessRequestFilter{State: state},
+			Limit:    int32(w.config.PageSize),
+			StartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s access requests: %w", state, err)
+		}
+
+		for _, req := range resp.AccessRequests {
+			if expiry := req.GetAccessExpiry(); !expiry.IsZero() && expiry.Before(now) {
+				continue
+			}
+			parsed = append(parsed, w.parseAccessRequest(req))
+		}
+
+		if resp.NextKey == "" || resp.NextKey == startKey {
+			break
+		}
+		startKey = resp.NextKey
 	}
 
-	w.logDebug("Successfully fetched %d access requests", len(requests))
+	return parsed, nil
+}
 
-	// Parse requests
+// getAllAccessRequests fetches all pending and approved access requests and
+// returns the set of users whose requests changed since the previous call
+func (w *Watcher) getAllAccessRequests(ctx context.Context) ([]*AccessRequestInfo, map[string]bool, error) {
+	w.logDebug("Fetching pending and approved access requests")
+
+	// Only pending and approved requests can need action
 	var parsed []*AccessRequestInfo
+	for _, state := range []types.RequestState{types.RequestState_PENDING, types.RequestState_APPROVED} {
+		requests, err := w.listAccessRequests(ctx, state)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed = append(parsed, requests...)
+	}
+
+	w.logDebug("Successfully fetched %d access requests", len(parsed))
+
+	return parsed, w.updateRequestCache(parsed), nil
+}
+
+// updateRequestCache records the current version of every request and returns
+// the users that have a new, modified or removed request
+func (w *Watcher) updateRequestCache(requests []*AccessRequestInfo) map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changedUsers := make(map[string]bool)
+	seen := make(map[string]bool, len(requests))
+
 	for _, req := range requests {
-		info := w.parseAccessRequest(req)
-		parsed = append(parsed, info)
+		seen[req.ID] = true
+		cached, ok := w.requestCache[req.ID]
+		if ok && cached.revision == req.Revision {
+			continue
+		}
+		w.logDebug("Request %s for user %s is new or changed", req.ID, req.User)
+		w.requestCache[req.ID] = &cachedRequest{revision: req.Revision, user: req.User}
+		changedUsers[req.User] = true
 	}
 
-	return parsed, nil
+	for id, cached := range w.requestCache {
+		if !seen[id] {
+			w.logDebug("Request %s for user %s is no longer pending or approved", id, cached.user)
+			delete(w.requestCache, id)
+			changedUsers[cached.user] = true
+		}
+	}
+
+	return changedUsers
+}
+
+// invalidateRequest drops a request from the cache so that its user is
+// reevaluated on the next poll, e.g. after an enforcement call failed
+func (w *Watcher) invalidateRequest(requestID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.requestCache, requestID)
 }
 
 // getApprovedRequestsByUser groups approved requests by user
@@ -182,14 +636,15 @@ func (w *Watcher) hasEnvironmentConflict(roles []string) (bool, map[string][]str
 }
 
 // approveAccessRequest approves a specific access request
-func (w *Watcher) approveAccessRequest(ctx context.Context, requestID string, reason string) error {
+func (w *Watcher) approveAccessRequest(ctx context.Context, requestID string, reason string, decision policyDecision) error {
 	w.logDebug("Attempting to approve access request: %s", requestID)
 
 	// Approve the request
 	err := w.client.SetAccessRequestState(ctx, types.AccessRequestUpdate{
-		RequestID: requestID,
-		State:     types.RequestState_APPROVED,
-		Reason:    reason,
+		RequestID:   requestID,
+		State:       types.RequestState_APPROVED,
+		Reason:      reason,
+		Annotations: decision.annotations(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to approve request: %w", err)
@@ -199,14 +654,15 @@ func (w *Watcher) approveAccessRequest(ctx context.Context, requestID string, re
 }
 
 // denyAccessRequest denies a specific access request
-func (w *Watcher) denyAccessRequest(ctx context.Context, requestID string, reason string) error {
+func (w *Watcher) denyAccessRequest(ctx context.Context, requestID string, reason string, decision policyDecision) error {
 	w.logDebug("Attempting to deny access request: %s", requestID)
 
 	// Deny the request
 	err := w.client.SetAccessRequestState(ctx, types.AccessRequestUpdate{
-		RequestID: requestID,
-		State:     types.RequestState_DENIED,
-		Reason:    reason,
+		RequestID:   requestID,
+		State:       types.RequestState_DENIED,
+		Reason:      reason,
+		Annotations: decision.annotations(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to deny request: %w", err)
@@ -215,7 +671,9 @@ func (w *Watcher) denyAccessRequest(ctx context.Context, requestID string, reaso
 	return nil
 }
 
-func (w *Watcher) lockAccessRequest(ctx context.Context, requestID string, reason string) error {
+// lockAccessRequest locks a specific access request. The lock is labeled with
+// the decision so it can be traced back to the policy that created it.
+func (w *Watcher) lockAccessRequest(ctx context.Context, requestID string, reason string, decision policyDecision) error {
 	w.logDebug("Attempting to lock access request: %s", requestID)
 
 	// Create lock resource
@@ -230,6 +688,9 @@ func (w *Watcher) lockAccessRequest(ctx context.Context, requestID string, reaso
 	if err != nil {
 		return fmt.Errorf("failed to create lock: %w", err)
 	}
+	if lockV2, ok := lock.(*types.LockV2); ok {
+		lockV2.Metadata.Labels = decision.labels()
+	}
 
 	// Create the lock
 	err = w.client.UpsertLock(ctx, lock)
@@ -237,10 +698,138 @@ func (w *Watcher) lockAccessRequest(ctx context.Context, requestID string, reaso
 		return fmt.Errorf("failed to create lock: %w", err)
 	}
 
-	w.lockedRequests[requestID] = true
 	return nil
 }
 
+// startWorkers launches the enforcement workers. Each task runs under the
+// context of the pass that queued it, so the pass deadline bounds it too.
+// Workers keep draining the queue after that context is cancelled so that
+// waitForTasks never blocks forever; tasks then fail fast with the context
+// error.
+func (w *Watcher) startWorkers() {
+	for i := 0; i < w.config.Workers; i++ {
+		go func() {
+			for task := range w.tasks {
+				w.runTask(task.ctx, task)
+			}
+		}()
+	}
+	w.logDebug("Started %d enforcement workers", w.config.Workers)
+}
+
+// stopWorkers closes the queue so the workers exit once it is drained. It
+// must only be called when no more tasks can be enqueued.
+func (w *Watcher) stopWorkers() {
+	close(w.tasks)
+}
+
+// enqueue schedules a task unless a task with the same idempotency key is
+// already queued or running. Returns false if the task was not scheduled.
+func (w *Watcher) enqueue(ctx context.Context, task *enforcementTask) bool {
+	w.mu.Lock()
+	if w.inFlight[task.key] {
+		w.mu.Unlock()
+		w.logDebug("Skipping %s: already in progress", task.desc)
+		return false
+	}
+	w.inFlight[task.key] = true
+	w.mu.Unlock()
+
+	task.ctx = ctx
+	w.pending.Add(1)
+	select {
+	case w.tasks <- task:
+		return true
+	case <-ctx.Done():
+		w.finishTask(task, ctx.Err())
+		return false
+	}
+}
+
+// isPermanent reports whether err is one that a retry cannot fix, such as
+// missing permissions or a request that no longer exists
+func isPermanent(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if trace.IsAccessDenied(err) || trace.IsNotFound(err) || trace.IsBadParameter(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// runTask performs a task, retrying with exponential backoff on failure.
+// Permanent errors are not retried.
+func (w *Watcher) runTask(ctx context.Context, task *enforcementTask) {
+	delay := w.config.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= w.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			w.logDebug("Retrying %s in %s (attempt %d/%d): %v", task.desc, delay, attempt, w.config.MaxRetries, err)
+			select {
+			case <-ctx.Done():
+				w.finishTask(task, ctx.Err())
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, apiCallTimeout)
+		err = task.run(attemptCtx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if isPermanent(err) {
+			w.logDebug("Not retrying %s: %v", task.desc, err)
+			break
+		}
+	}
+	w.finishTask(task, err)
+}
+
+// finishTask reports the final result of a task and releases its idempotency key
+func (w *Watcher) finishTask(task *enforcementTask, err error) {
+	if task.onDone != nil {
+		task.onDone(err)
+	}
+	w.mu.Lock()
+	delete(w.inFlight, task.key)
+	w.mu.Unlock()
+	w.pending.Done()
+}
+
+// waitForTasks blocks until every task queued so far has finished
+func (w *Watcher) waitForTasks() {
+	w.pending.Wait()
+}
+
+// scheduleLock marks a request as locked and queues the lock call. If the lock
+// still fails after all retries the mark is cleared so the next poll retries it.
+func (w *Watcher) scheduleLock(ctx context.Context, req *AccessRequestInfo, reason string, successMsg string, decision policyDecision) {
+	w.mu.Lock()
+	w.lockedRequests[req.ID] = true
+	w.mu.Unlock()
+
+	w.enqueue(ctx, &enforcementTask{
+		key:  "lock/" + req.ID,
+		desc: "lock of request " + req.ID,
+		run: func(ctx context.Context) error {
+			return w.lockAccessRequest(ctx, req.ID, reason, decision)
+		},
+		onDone: func(err error) {
+			if err != nil {
+				w.logError("Failed to lock request %s: %v", req.ID, err)
+				w.mu.Lock()
+				delete(w.lockedRequests, req.ID)
+				w.mu.Unlock()
+				w.invalidateRequest(req.ID)
+				return
+			}
+			w.logInfo("Successfully locked request %s%s", req.ID, successMsg)
+		},
+	})
+}
+
 // validateAndProcessPendingRequests processes pending requests for auto-approval
 func (w *Watcher) validateAndProcessPendingRequests(ctx context.Context, requests []*AccessRequestInfo) []*AccessRequestInfo {
 	w.logInfo("=== Processing pending requests for auto-approval ===")
@@ -275,11 +864,13 @@ func (w *Watcher) validateAndProcessPendingRequests(ctx context.Context, request
 
 		var shouldApprove = true
 		var denyReason string
+		var denyPolicy string
 
 		// Check resource limit
 		if w.config.CheckResources && resourceCount > w.config.MaxResources {
 			shouldApprove = false
 			denyReason = fmt.Sprintf("Request contains %d resources, exceeds limit of %d", resourceCount, w.config.MaxResources)
+			denyPolicy = PolicyResourceLimit
 			w.logInfo("Request %s violates resource policy: %s", req.ID, denyReason)
 		}
 
@@ -291,39 +882,71 @@ func (w *Watcher) validateAndProcessPendingRequests(ctx context.Context, request
 				conflictDetails = append(conflictDetails, fmt.Sprintf("%s: %v", pattern, roles))
 			}
 			denyReason = fmt.Sprintf("Request contains conflicting environments - %s", strings.Join(conflictDetails, ", "))
+			denyPolicy = PolicyEnvironmentConflict
 			w.logInfo("Request %s violates environment policy: %s", req.ID, denyReason)
 		}
 
-		// Process the request
+		// Queue the decision
 		if shouldApprove {
+			decision := w.decision(PolicyAutoApprove)
 			approveReason := "Auto-approved: complies with access policies"
 			w.logInfo("Auto-approving request %s (%d resources)", req.ID, resourceCount)
-			
-			if err := w.approveAccessRequest(ctx, req.ID, approveReason); err != nil {
-				w.logError("Failed to approve request %s: %v", req.ID, err)
-			} else {
-				w.logInfo("Successfully approved request %s", req.ID)
-				// Update the request state and add to processed list
-				req.State = types.RequestState_APPROVED
-				processedRequests = append(processedRequests, req)
-			}
+
+			w.enqueue(ctx, &enforcementTask{
+				key:  "approve/" + req.ID,
+				desc: "approval of request " + req.ID,
+				run: func(ctx context.Context) error {
+					return w.approveAccessRequest(ctx, req.ID, approveReason, decision)
+				},
+				onDone: func(err error) {
+					if err != nil {
+						w.logError("Failed to approve request %s: %v", req.ID, err)
+						w.invalidateRequest(req.ID)
+						return
+					}
+					w.logInfo("Successfully approved request %s", req.ID)
+					// Update the request state so it is picked up below
+					req.State = types.RequestState_APPROVED
+				},
+			})
 		} else {
 			w.logInfo("Auto-denying request %s: %s", req.ID, denyReason)
-			
-			if err := w.denyAccessRequest(ctx, req.ID, denyReason); err != nil {
-				w.logError("Failed to deny request %s: %v", req.ID, err)
-			} else {
-				w.logInfo("Successfully denied request %s", req.ID)
-				// Don't add denied requests to processed list
-			}
+			decision := w.decision(denyPolicy)
+
+			w.enqueue(ctx, &enforcementTask{
+				key:  "deny/" + req.ID,
+				desc: "denial of request " + req.ID,
+				run: func(ctx context.Context) error {
+					return w.denyAccessRequest(ctx, req.ID, denyReason, decision)
+				},
+				onDone: func(err error) {
+					if err != nil {
+						w.logError("Failed to deny request %s: %v", req.ID, err)
+						w.invalidateRequest(req.ID)
+						return
+					}
+					w.logInfo("Successfully denied request %s", req.ID)
+				},
+			})
+		}
+	}
+
+	// Wait for the decisions, then add approved requests to the processed list.
+	// Denied requests are not added.
+	w.waitForTasks()
+	for _, req := range pendingRequests {
+		if req.State == types.RequestState_APPROVED {
+			processedRequests = append(processedRequests, req)
 		}
 	}
 
 	return processedRequests
 }
 
-// isRequestLocked checks if we've locked this request during this session
+// isRequestLocked checks if we've locked (or queued a lock for) this request during this session
 func (w *Watcher) isRequestLocked(requestID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.lockedRequests[requestID]
 }
 
@@ -347,6 +970,302 @@ func (w *Watcher) hasRolePattern(roles []string, pattern *regexp.Regexp) bool {
 	return false
 }
 
+// rolePriority returns the priority of a role from its PriorityLabel label.
+// Roles without the label, or that cannot be read, have priority 0.
+func (w *Watcher) rolePriority(ctx context.Context, name string) int {
+	if priority, ok := w.rolePriorities[name]; ok {
+		return priority
+	}
+
+	priority := 0
+	role, err := w.client.GetRole(ctx, name)
+	if err != nil {
+		w.logError("Failed to read role %s for priority: %v", name, err)
+	} else if value, ok := role.GetMetadata().Labels[w.config.PriorityLabel]; ok {
+		if priority, err = strconv.Atoi(value); err != nil {
+			w.logError("Role %s has non-integer %s label %q", name, w.config.PriorityLabel, value)
+			priority = 0
+		}
+	}
+
+	w.rolePriorities[name] = priority
+	return priority
+}
+
+// requestPriority returns the highest priority among a request's roles
+func (w *Watcher) requestPriority(ctx context.Context, req *AccessRequestInfo) int {
+	highest := 0
+	for i, role := range req.Roles {
+		if priority := w.rolePriority(ctx, role); i == 0 || priority > highest {
+			highest = priority
+		}
+	}
+	return highest
+}
+
+// sortBySurvival orders requests so that the ones the configured strategy
+// prefers to keep come first. Ties are broken by keeping the newest request.
+// lock-all-and-notify keeps the default newest-first order.
+func (w *Watcher) sortBySurvival(ctx context.Context, requests []*AccessRequestInfo) {
+	newer := func(i, j int) bool {
+		return requests[i].Created.After(requests[j].Created)
+	}
+
+	switch w.config.ConflictStrategy {
+	case StrategyKeepOldest:
+		sort.SliceStable(requests, func(i, j int) bool {
+			return requests[i].Created.Before(requests[j].Created)
+		})
+	case StrategyKeepHighestPriority:
+		priorities := make(map[string]int, len(requests))
+		for _, req := range requests {
+			priorities[req.ID] = w.requestPriority(ctx, req)
+		}
+		sort.SliceStable(requests, func(i, j int) bool {
+			if pi, pj := priorities[requests[i].ID], priorities[requests[j].ID]; pi != pj {
+				return pi > pj
+			}
+			return newer(i, j)
+		})
+	case StrategyKeepSmallest:
+		sort.SliceStable(requests, func(i, j int) bool {
+			if ci, cj := w.countResources(requests[i]), w.countResources(requests[j]); ci != cj {
+				return ci < cj
+			}
+			return newer(i, j)
+		})
+	default:
+		sort.SliceStable(requests, newer)
+	}
+}
+
+// notifyLockAll reports requests locked by the lock-all-and-notify strategy.
+// The notification is always logged and, when NotifyWebhook is set, posted
+// as JSON through the enforcement queue.
+func (w *Watcher) notifyLockAll(ctx context.Context, user string, decision policyDecision, requests []*AccessRequestInfo, reason string) {
+	ids := make([]string, len(requests))
+	for i, req := range requests {
+		ids[i] = req.ID
+	}
+	log.Printf("[NOTIFY] Locked all %d requests of user %s for %s: %s (requests: %s)",
+		len(ids), user, decision.PolicyID, reason, strings.Join(ids, ","))
+
+	if w.config.NotifyWebhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"user":           user,
+		"policy":         decision.PolicyID,
+		"policy_version": decision.Version,
+		"evaluated_at":   decision.EvaluatedAt.Format(time.RFC3339),
+		"reason":         reason,
+		"request_ids":    ids,
+		"strategy":       w.config.ConflictStrategy,
+		"time":           time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		w.logError("Failed to encode notification for user %s: %v", user, err)
+		return
+	}
+
+	w.enqueue(ctx, &enforcementTask{
+		key:  "notify/" + decision.PolicyID + "/" + strings.Join(ids, ","),
+		desc: "notification for user " + user,
+		run: func(ctx context.Context) error {
+			return w.postWebhook(ctx, body)
+		},
+		onDone: func(err error) {
+			if err != nil {
+				w.logError("Failed to send notification for user %s: %v", user, err)
+			}
+		},
+	})
+}
+
+// postWebhook posts a JSON body to the notification webhook
+func (w *Watcher) postWebhook(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.NotifyWebhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// lookupUser returns a Teleport user (reviewer or requester), or nil if it
+// cannot be read
+func (w *Watcher) lookupUser(ctx context.Context, name string) types.User {
+	if user, ok := w.users[name]; ok {
+		return user
+	}
+
+	user, err := w.client.GetUser(ctx, name, false)
+	if err != nil {
+		w.logError("Failed to read user %s: %v", name, err)
+	}
+	w.users[name] = user
+	return user
+}
+
+// isBotIdentity reports whether a user name belongs to a Machine ID bot
+func (w *Watcher) isBotIdentity(ctx context.Context, name string) bool {
+	if strings.HasPrefix(name, "bot-") {
+		return true
+	}
+	if user := w.lookupUser(ctx, name); user != nil {
+		if _, ok := user.GetMetadata().Labels[types.BotLabel]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesTeam reports whether two users have a common value for the team trait
+func (w *Watcher) sharesTeam(ctx context.Context, reviewerName string, requesterName string) (bool, string) {
+	reviewer := w.lookupUser(ctx, reviewerName)
+	requester := w.lookupUser(ctx, requesterName)
+	if reviewer == nil || requester == nil {
+		return false, ""
+	}
+
+	for _, reviewerTeam := range reviewer.GetTraits()[w.config.SoDTeamTrait] {
+		for _, requesterTeam := range requester.GetTraits()[w.config.SoDTeamTrait] {
+			if reviewerTeam == requesterTeam {
+				return true, reviewerTeam
+			}
+		}
+	}
+	return false, ""
+}
+
+// approvalReviews returns the approving reviews of a request
+func (w *Watcher) approvalReviews(req *AccessRequestInfo) []types.AccessReview {
+	var approvals []types.AccessReview
+	for _, review := range req.Reviews {
+		if review.ProposedState == types.RequestState_APPROVED {
+			approvals = append(approvals, review)
+		}
+	}
+	return approvals
+}
+
+// separationOfDutiesViolations checks the reviews on a user's approved
+// requests and returns the reason for each request that violates a
+// separation-of-duties rule, keyed by request ID
+func (w *Watcher) separationOfDutiesViolations(ctx context.Context, userRequests []*AccessRequestInfo) map[string]string {
+	violations := make(map[string]string)
+
+	// approval is one approving review, used for the daily approval count
+	type approval struct {
+		reviewer string
+		created  time.Time
+		req      *AccessRequestInfo
+	}
+	var approvals []approval
+
+	for _, req := range userRequests {
+		for _, review := range w.approvalReviews(req) {
+			approvals = append(approvals, approval{reviewer: review.Author, created: review.Created, req: req})
+
+			if _, found := violations[req.ID]; found {
+				continue
+			}
+
+			// Approvals from bot identities
+			if w.isBotIdentity(ctx, review.Author) {
+				violations[req.ID] = fmt.Sprintf("Separation of duties: approved by bot identity %s", review.Author)
+				continue
+			}
+
+			// Approvals of production roles by a reviewer on the requester's team
+			if !w.hasRolePattern(req.Roles, w.sodProdPattern) {
+				continue
+			}
+			if same, team := w.sharesTeam(ctx, review.Author, req.User); same {
+				violations[req.ID] = fmt.Sprintf("Separation of duties: %s approved a production role for a member of their own team (%s)",
+					review.Author, team)
+			}
+		}
+	}
+
+	if w.config.SoDMaxDailyApprovals < 1 {
+		return violations
+	}
+
+	// Too many approvals by the same reviewer for this user within 24 hours.
+	// Approvals beyond the limit are violations, the earlier ones are kept.
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].created.Before(approvals[j].created)
+	})
+	for i, current := range approvals {
+		count := 0
+		for _, previous := range approvals[:i+1] {
+			if previous.reviewer == current.reviewer && current.created.Sub(previous.created) < 24*time.Hour {
+				count++
+			}
+		}
+		if _, found := violations[current.req.ID]; !found && count > w.config.SoDMaxDailyApprovals {
+			violations[current.req.ID] = fmt.Sprintf("Separation of duties: %s approved %d requests for %s within 24h (limit %d)",
+				current.reviewer, count, current.req.User, w.config.SoDMaxDailyApprovals)
+		}
+	}
+
+	return violations
+}
+
+// processSeparationOfDuties locks approved requests whose reviews violate
+// separation-of-duties rules and returns the remaining requests
+func (w *Watcher) processSeparationOfDuties(ctx context.Context, userRequests []*AccessRequestInfo) []*AccessRequestInfo {
+	if !w.config.CheckSeparationOfDuties {
+		return userRequests
+	}
+
+	if len(userRequests) == 0 {
+		return userRequests
+	}
+
+	user := userRequests[0].User
+	w.logInfo("Checking separation of duties for user %s", user)
+
+	violations := w.separationOfDutiesViolations(ctx, userRequests)
+
+	var unlockedRequests []*AccessRequestInfo
+	for _, req := range userRequests {
+		reason, found := violations[req.ID]
+		if !found {
+			if !w.isRequestLocked(req.ID) {
+				unlockedRequests = append(unlockedRequests, req)
+			}
+			continue
+		}
+
+		if w.isRequestLocked(req.ID) {
+			w.logInfo("Request %s already locked", req.ID)
+			continue
+		}
+		w.logInfo("Locking request %s: %s", req.ID, reason)
+		w.scheduleLock(ctx, req, reason, " for separation of duties", w.decision(PolicySeparationOfDuties))
+	}
+
+	w.logInfo("After separation-of-duties check: %d/%d requests remain unlocked",
+		len(unlockedRequests), len(userRequests))
+	return unlockedRequests
+}
+
 // processEnvironmentConflicts handles conflicts for a user
 func (w *Watcher) processEnvironmentConflicts(ctx context.Context, userRequests []*AccessRequestInfo) []*AccessRequestInfo {
 	if !w.config.CheckConflicts {
@@ -373,12 +1292,7 @@ func (w *Watcher) processEnvironmentConflicts(ctx context.Context, userRequests
 			}
 			reason := fmt.Sprintf("Single request contains conflicting roles: %s", strings.Join(conflictDetails, ", "))
 			w.logInfo("Locking request %s: %s", req.ID, reason)
-			
-			if err := w.lockAccessRequest(ctx, req.ID, reason); err != nil {
-				w.logError("Failed to lock conflicted request %s: %v", req.ID, err)
-			} else {
-				w.logInfo("Successfully locked conflicted request %s", req.ID)
-			}
+			w.scheduleLock(ctx, req, reason, " (single-request conflict)", w.decision(PolicyEnvironmentConflict))
 		} else {
 			requestsToProcess = append(requestsToProcess, req)
 		}
@@ -426,31 +1340,34 @@ func (w *Watcher) processEnvironmentConflicts(ctx context.Context, userRequests
 	}
 
 	if len(conflictRequests) > 1 {
-		// Sort by creation time (oldest first for locking)
-		sort.Slice(conflictRequests, func(i, j int) bool {
-			return conflictRequests[i].Created.Before(conflictRequests[j].Created)
-		})
-
-		// Lock all but the newest
-		requestsToLock := conflictRequests[:len(conflictRequests)-1]
-		w.logInfo("Locking %d older requests due to multi-request environment conflict", len(requestsToLock))
+		reason := fmt.Sprintf("Multi-request environment conflict: user has conflicting access across requests (%s)",
+			strings.Join(w.config.ConflictPatterns, " vs "))
+
+		// Order by the configured strategy and lock all but the first
+		w.sortBySurvival(ctx, conflictRequests)
+		requestsToLock := conflictRequests[1:]
+		if w.config.ConflictStrategy == StrategyLockAllAndNotify {
+			requestsToLock = conflictRequests
+		}
+		w.logInfo("Locking %d requests due to multi-request environment conflict (strategy: %s)",
+			len(requestsToLock), w.config.ConflictStrategy)
 
+		newLocks := 0
 		for _, req := range requestsToLock {
 			if w.isRequestLocked(req.ID) {
 				w.logInfo("Request %s already locked", req.ID)
 			} else {
-				reason := fmt.Sprintf("Multi-request environment conflict: user has conflicting access across requests (%s)", 
-					strings.Join(w.config.ConflictPatterns, " vs "))
 				w.logInfo("Locking request %s (created: %s, roles: %v)",
 					req.ID, req.Created.Format(time.RFC3339), req.Roles)
-
-				if err := w.lockAccessRequest(ctx, req.ID, reason); err != nil {
-					w.logError("Failed to lock request %s: %v", req.ID, err)
-				} else {
-					w.logInfo("Successfully locked request %s for environment conflict", req.ID)
-				}
+				w.scheduleLock(ctx, req, reason, " for environment conflict", w.decision(PolicyEnvironmentConflict))
+				newLocks++
 			}
 		}
+
+		// Only notify when this pass locked something, not on every re-evaluation
+		if w.config.ConflictStrategy == StrategyLockAllAndNotify && newLocks > 0 {
+			w.notifyLockAll(ctx, user, w.decision(PolicyEnvironmentConflict), requestsToLock, reason)
+		}
 	}
 
 	// Return unlocked requests
@@ -497,14 +1414,19 @@ func (w *Watcher) processResourceLimits(ctx context.Context, userRequests []*Acc
 	w.logInfo("User %s has %d resources, need to reduce to %d",
 		user, totalResources, w.config.MaxResources)
 
-	// Keep requests until we hit the limit
+	// Keep requests in the configured strategy's order until we hit the limit
+	w.sortBySurvival(ctx, userRequests)
 	resourcesToKeep := w.config.MaxResources
 	var requestsToLock []*AccessRequestInfo
 
 	for _, req := range userRequests {
 		resourceCount := w.countResources(req)
 
-		if resourcesToKeep >= resourceCount {
+		if w.config.ConflictStrategy == StrategyLockAllAndNotify {
+			requestsToLock = append(requestsToLock, req)
+			w.logDebug("Marking request %s for locking (%d resources, strategy: %s)",
+				req.ID, resourceCount, w.config.ConflictStrategy)
+		} else if resourcesToKeep >= resourceCount {
 			resourcesToKeep -= resourceCount
 			w.logDebug("Keeping request %s with %d resources (remaining quota: %d)",
 				req.ID, resourceCount, resourcesToKeep)
@@ -516,13 +1438,15 @@ func (w *Watcher) processResourceLimits(ctx context.Context, userRequests []*Acc
 
 	// Lock excess requests
 	if len(requestsToLock) > 0 {
-		w.logInfo("Locking %d requests to enforce resource limit", len(requestsToLock))
+		w.logInfo("Locking %d requests to enforce resource limit (strategy: %s)",
+			len(requestsToLock), w.config.ConflictStrategy)
+		reason := fmt.Sprintf("Exceeded maximum approved resources limit (%d)", w.config.MaxResources)
 
+		newLocks := 0
 		for _, req := range requestsToLock {
 			if w.isRequestLocked(req.ID) {
 				w.logInfo("Request %s already locked", req.ID)
 			} else {
-				reason := fmt.Sprintf("Exceeded maximum approved resources limit (%d)", w.config.MaxResources)
 				resourceNames := make([]string, len(req.Resources))
 				for i, res := range req.Resources {
 					resourceNames[i] = fmt.Sprintf("%s:%s", res.Kind, res.Name)
@@ -530,14 +1454,14 @@ func (w *Watcher) processResourceLimits(ctx context.Context, userRequests []*Acc
 
 				w.logInfo("Locking request %s (created: %s, %d resources: %s)",
 					req.ID, req.Created.Format(time.RFC3339), len(req.Resources), strings.Join(resourceNames, ","))
-
-				if err := w.lockAccessRequest(ctx, req.ID, reason); err != nil {
-					w.logError("Failed to lock request %s: %v", req.ID, err)
-				} else {
-					w.logInfo("Successfully locked request %s for resource limit", req.ID)
-				}
+				w.scheduleLock(ctx, req, reason, " for resource limit", w.decision(PolicyResourceLimit))
+				newLocks++
 			}
 		}
+
+		if w.config.ConflictStrategy == StrategyLockAllAndNotify && newLocks > 0 {
+			w.notifyLockAll(ctx, user, w.decision(PolicyResourceLimit), requestsToLock, reason)
+		}
 	}
 }
 
@@ -545,8 +1469,12 @@ func (w *Watcher) processResourceLimits(ctx context.Context, userRequests []*Acc
 func (w *Watcher) processAllUsers(ctx context.Context) error {
 	w.logInfo("=== Processing all users ===")
 
-	// Get all access requests
-	allRequests, err := w.getAllAccessRequests(ctx)
+	// Wait for queued calls so each pass finishes its enforcement before the
+	// next one, including passes that return early after approving or denying
+	defer w.waitForTasks()
+
+	// Get all pending and approved access requests
+	allRequests, changedUsers, err := w.getAllAccessRequests(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get access requests: %w", err)
 	}
@@ -556,10 +1484,30 @@ func (w *Watcher) processAllUsers(ctx context.Context) error {
 		return nil
 	}
 
-	w.logInfo("Found %d total access requests", len(allRequests))
+	w.logInfo("Found %d total access requests, %d user(s) with changes", len(allRequests), len(changedUsers))
+
+	if len(changedUsers) == 0 {
+		w.logDebug("No access request changes since last poll")
+		return nil
+	}
+
+	// Only reevaluate users with new, modified or removed requests
+	var changedRequests []*AccessRequestInfo
+	for _, req := range allRequests {
+		if changedUsers[req.User] {
+			changedRequests = append(changedRequests, req)
+		}
+	}
+
+	// Every decision in this pass is recorded with the same evaluation time
+	w.evaluatedAt = time.Now().UTC()
+
+	// Role priorities and reviewers are looked up fresh on every pass
+	w.rolePriorities = make(map[string]int)
+	w.users = make(map[string]types.User)
 
 	// Step 1: Process pending requests for auto-approval/denial
-	processedRequests := w.validateAndProcessPendingRequests(ctx, allRequests)
+	processedRequests := w.validateAndProcessPendingRequests(ctx, changedRequests)
 
 	// Step 2: Group approved requests by user (after auto-approval processing)
 	approvedByUser := w.getApprovedRequestsByUser(processedRequests)
@@ -573,8 +1521,11 @@ func (w *Watcher) processAllUsers(ctx context.Context) error {
 	for user, userRequests := range approvedByUser {
 		w.logInfo("\n=== Processing approved requests for user: %s ===", user)
 
-		// Check environment conflicts first
-		unlockedRequests := w.processEnvironmentConflicts(ctx, userRequests)
+		// Check the reviewers of approved requests first
+		unlockedRequests := w.processSeparationOfDuties(ctx, userRequests)
+
+		// Then check environment conflicts
+		unlockedRequests = w.processEnvironmentConflicts(ctx, unlockedRequests)
 
 		// Check resource limits on remaining unlocked requests
 		w.processResourceLimits(ctx, unlockedRequests)
@@ -583,12 +1534,226 @@ func (w *Watcher) processAllUsers(ctx context.Context) error {
 	return nil
 }
 
+// recordPass updates the health state with the outcome of an evaluation pass.
+// After a failure the connection is pinged to tell broken connectivity apart
+// from other errors.
+func (w *Watcher) recordPass(ctx context.Context, passErr error) {
+	connected := true
+	if passErr != nil {
+		pingCtx, cancel := context.WithTimeout(ctx, apiCallTimeout)
+		_, err := w.client.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			w.logError("Teleport connectivity check failed: %v", err)
+			connected = false
+		}
+	}
+
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+
+	w.health.connected = connected
+	if passErr != nil {
+		w.health.lastError = passErr.Error()
+		w.health.consecutiveFailures++
+		return
+	}
+	w.health.lastSuccess = time.Now()
+	w.health.lastError = ""
+	w.health.consecutiveFailures = 0
+}
+
+// failingFor returns how long there has been no successful evaluation, counted
+// from the last success, the last watchdog reconnect or startup
+func (w *Watcher) failingFor() time.Duration {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+
+	since := w.health.started
+	for _, t := range []time.Time{w.health.lastSuccess, w.health.lastReconnect} {
+		if t.After(since) {
+			since = t
+		}
+	}
+	return time.Since(since)
+}
+
+// runCheck runs one evaluation pass and records its outcome. With the
+// watchdog enabled the pass, and the enforcement calls it queues, is bounded
+// by the watchdog threshold. The watchdog can also cancel it.
+func (w *Watcher) runCheck(ctx context.Context) error {
+	var (
+		passCtx context.Context
+		cancel  context.CancelFunc
+	)
+	if w.config.WatchdogThreshold > 0 {
+		passCtx, cancel = context.WithTimeout(ctx, w.config.WatchdogThreshold)
+	} else {
+		passCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	w.health.mu.Lock()
+	w.health.cancelPass = cancel
+	w.health.mu.Unlock()
+
+	err := w.processAllUsers(passCtx)
+
+	w.health.mu.Lock()
+	w.health.cancelPass = nil
+	w.health.mu.Unlock()
+
+	w.recordPass(ctx, err)
+	return err
+}
+
+// checkWatchdog acts when no evaluation has succeeded for longer than the
+// watchdog threshold. The exit action returns an error so the process exits
+// and can be restarted (e.g. by Kubernetes). Reconnect cancels the running
+// pass and leaves the client swap to the poll loop.
+func (w *Watcher) checkWatchdog() error {
+	failing := w.failingFor()
+	if failing <= w.config.WatchdogThreshold {
+		return nil
+	}
+
+	if w.config.WatchdogAction == WatchdogExit {
+		return fmt.Errorf("watchdog: no successful evaluation for %s (threshold %s)",
+			failing.Round(time.Second), w.config.WatchdogThreshold)
+	}
+
+	w.logError("Watchdog: no successful evaluation for %s, reconnecting to Teleport", failing.Round(time.Second))
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	w.health.lastReconnect = time.Now()
+	w.health.reconnectDue = true
+	if w.health.cancelPass != nil {
+		w.health.cancelPass()
+	}
+	return nil
+}
+
+// runWatchdog checks the watchdog on its own ticker, so that it fires even
+// while a pass is stuck. On exit it reports the error and calls stop, which
+// cancels the running pass and ends the poll loop.
+func (w *Watcher) runWatchdog(ctx context.Context, stop context.CancelFunc, errChan chan<- error) {
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.checkWatchdog(); err != nil {
+				errChan <- err
+				stop()
+				return
+			}
+		}
+	}
+}
+
+// reconnect replaces the Teleport client when the watchdog has asked for it.
+// It is called by the poll loop before each pass, when no tasks are running,
+// so the client can be swapped safely.
+func (w *Watcher) reconnect(ctx context.Context) {
+	w.health.mu.Lock()
+	due := w.health.reconnectDue
+	w.health.reconnectDue = false
+	w.health.mu.Unlock()
+	if !due {
+		return
+	}
+
+	teleportClient, err := newClient(ctx, w.config)
+	if err != nil {
+		w.logError("Watchdog reconnect failed: %v", err)
+		return
+	}
+
+	oldClient := w.client
+	w.client = teleportClient
+	oldClient.Close()
+	w.logInfo("Watchdog reconnected to Teleport")
+}
+
+// currentHealth returns the current health. Live means the watchdog threshold
+// has not been exceeded; ready additionally requires a successful evaluation
+// and a working Teleport connection.
+func (w *Watcher) currentHealth() (status healthStatus, live bool, ready bool) {
+	failing := w.failingFor()
+
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+
+	status = healthStatus{
+		Connected:           w.health.connected,
+		LastError:           w.health.lastError,
+		ConsecutiveFailures: w.health.consecutiveFailures,
+	}
+	if !w.health.lastSuccess.IsZero() {
+		status.LastSuccess = w.health.lastSuccess.UTC().Format(time.RFC3339)
+	}
+
+	live = w.config.WatchdogThreshold <= 0 || failing <= w.config.WatchdogThreshold
+	ready = live && w.health.connected && !w.health.lastSuccess.IsZero()
+	return status, live, ready
+}
+
+// writeHealth writes a health response with the given verdict
+func writeHealth(rw http.ResponseWriter, status healthStatus, ok bool) {
+	status.Status = "ok"
+	code := http.StatusOK
+	if !ok {
+		status.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(status)
+}
+
+// serveHealth serves /healthz and /readyz until ctx is done
+func (w *Watcher) serveHealth(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		status, live, _ := w.currentHealth()
+		writeHealth(rw, status, live)
+	})
+	mux.HandleFunc("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+		status, _, ready := w.currentHealth()
+		writeHealth(rw, status, ready)
+	})
+
+	server := &http.Server{
+		Addr:              w.config.HealthAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	w.logInfo("Serving health endpoints on %s", w.config.HealthAddr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		w.logError("Health endpoints stopped: %v", err)
+	}
+}
+
 // Watch starts the monitoring (polling version)
 func (w *Watcher) Watch(ctx context.Context) error {
 	w.logInfo("Starting Teleport JIT Access Request Watcher (Polling Mode)")
 	w.logInfo("Proxy Service: %s", w.config.ProxyServer)
 	w.logInfo("Identity File: %s", w.config.IdentityFile)
 	w.logInfo("Poll Interval: %s", w.config.PollInterval)
+	w.logInfo("Enforcement Workers: %d (max retries: %d, backoff: %s)",
+		w.config.Workers, w.config.MaxRetries, w.config.RetryBackoff)
 
 	policies := []string{}
 	if w.config.CheckConflicts {
@@ -598,7 +1763,23 @@ func (w *Watcher) Watch(ctx context.Context) error {
 	if w.config.CheckResources {
 		policies = append(policies, fmt.Sprintf("resource limit (%d)", w.config.MaxResources))
 	}
+	if w.config.CheckSeparationOfDuties {
+		policies = append(policies, fmt.Sprintf("separation of duties (team trait: %s, production pattern: %s, max daily approvals: %d)",
+			w.config.SoDTeamTrait, w.config.SoDProdPattern, w.config.SoDMaxDailyApprovals))
+	}
 	w.logInfo("Enabled policies: %s", strings.Join(policies, ", "))
+	w.logInfo("Conflict strategy: %s", w.config.ConflictStrategy)
+	for _, id := range []string{PolicyAutoApprove, PolicyResourceLimit, PolicyEnvironmentConflict, PolicySeparationOfDuties} {
+		w.logDebug("Policy %s version: %s", id, w.policyVersions[id])
+	}
+	if w.config.WatchdogThreshold > 0 {
+		w.logInfo("Watchdog: %s after %s without a successful evaluation", w.config.WatchdogAction, w.config.WatchdogThreshold)
+	}
+
+	// Start health endpoints
+	if w.config.HealthAddr != "" {
+		go w.serveHealth(ctx)
+	}
 
 	// Test connection
 	_, err := w.client.Ping(ctx)
@@ -606,6 +1787,22 @@ func (w *Watcher) Watch(ctx context.Context) error {
 		return fmt.Errorf("failed to ping Teleport: %w", err)
 	}
 	w.logInfo("Successfully connected to Teleport cluster")
+	w.health.mu.Lock()
+	w.health.connected = true
+	w.health.mu.Unlock()
+
+	// Start enforcement workers
+	w.startWorkers()
+	defer w.stopWorkers()
+
+	// Start the watchdog. It stops the poll loop through loopCtx, so it does
+	// not depend on a pass returning by itself.
+	loopCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	watchdogErr := make(chan error, 1)
+	if w.config.WatchdogThreshold > 0 {
+		go w.runWatchdog(loopCtx, stop, watchdogErr)
+	}
 
 	// Create ticker for polling
 	ticker := time.NewTicker(w.config.PollInterval)
@@ -613,19 +1810,25 @@ func (w *Watcher) Watch(ctx context.Context) error {
 
 	// Run initial check
 	w.logInfo("Running initial policy check...")
-	if err := w.processAllUsers(ctx); err != nil {
+	if err := w.runCheck(loopCtx); err != nil {
 		w.logError("Initial check failed: %v", err)
 	}
 
 	// Start polling loop
 	for {
 		select {
-		case <-ctx.Done():
+		case <-loopCtx.Done():
+			select {
+			case err := <-watchdogErr:
+				return err
+			default:
+			}
 			w.logInfo("Context cancelled, stopping watcher")
 			return ctx.Err()
 		case <-ticker.C:
+			w.reconnect(loopCtx)
 			w.logDebug("Running scheduled policy check...")
-			if err := w.processAllUsers(ctx); err != nil {
+			if err := w.runCheck(loopCtx); err != nil {
 				w.logError("Scheduled check failed: %v", err)
 			}
 		}
@@ -652,23 +1855,43 @@ func (s *StringSliceFlag) Set(value string) error {
 }
 
 func main() {
-	// Parse command line flags
-	var config Config
+	// Parse command line flags. Flags are bound to the defaults so that
+	// -h shows them; the file and environment are layered in after parsing.
+	config := defaultConfig()
 	var conflictPatterns StringSliceFlag
-	
-	flag.StringVar(&config.ProxyServer, "p", "", "Teleport auth service (required, e.g., example.teleport.sh:443)")
-	flag.StringVar(&config.IdentityFile, "i", "", "Path to Teleport identity file (required)")
-	flag.IntVar(&config.MaxResources, "m", 3, "Maximum approved resources per user")
-	flag.BoolVar(&config.CheckResources, "resource-limit", true, "Enable resource limit checking")
-	flag.BoolVar(&config.CheckConflicts, "role-conflicts", true, "Enable role conflict checking")
+	var configFile string
+	var printConfig bool
+
+	flag.StringVar(&configFile, "config", os.Getenv(envPrefix+"CONFIG"), "Path to a YAML config file (env: "+envPrefix+"CONFIG)")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the effective configuration as YAML, validate it and exit")
+	flag.StringVar(&config.ProxyServer, "p", config.ProxyServer, "Teleport auth service (required, e.g., example.teleport.sh:443)")
+	flag.StringVar(&config.IdentityFile, "i", config.IdentityFile, "Path to Teleport identity file (required)")
+	flag.IntVar(&config.MaxResources, "m", config.MaxResources, "Maximum approved resources per user")
+	flag.BoolVar(&config.CheckResources, "resource-limit", config.CheckResources, "Enable resource limit checking")
+	flag.BoolVar(&config.CheckConflicts, "role-conflicts", config.CheckConflicts, "Enable role conflict checking")
 	flag.Var(&conflictPatterns, "conflict-patterns", "Comma-separated patterns for conflict detection (default: prod,research)")
-	flag.DurationVar(&config.PollInterval, "poll-interval", 30*time.Second, "How often to check for policy violations")
-	flag.BoolVar(&config.Debug, "d", false, "Enable debug output")
+	flag.DurationVar(&config.PollInterval, "poll-interval", config.PollInterval, "How often to check for policy violations")
+	flag.BoolVar(&config.Debug, "d", config.Debug, "Enable debug output")
+	flag.IntVar(&config.Workers, "workers", config.Workers, "Number of concurrent workers for approve/deny/lock calls")
+	flag.IntVar(&config.MaxRetries, "max-retries", config.MaxRetries, "Retries per failed approve/deny/lock call before waiting for the next poll")
+	flag.DurationVar(&config.RetryBackoff, "retry-backoff", config.RetryBackoff, "Initial delay between retries (doubles on each attempt)")
+	flag.IntVar(&config.PageSize, "page-size", config.PageSize, "Number of access requests fetched per page")
+	flag.StringVar(&config.ConflictStrategy, "conflict-strategy", config.ConflictStrategy,
+		"Which requests stay unlocked on a violation: "+strings.Join(conflictStrategies, ", "))
+	flag.StringVar(&config.PriorityLabel, "priority-label", config.PriorityLabel, "Role label holding an integer priority (used by keep-highest-priority)")
+	flag.StringVar(&config.NotifyWebhook, "notify-webhook", config.NotifyWebhook, "URL that receives a JSON POST when lock-all-and-notify locks requests")
+	flag.BoolVar(&config.CheckSeparationOfDuties, "separation-of-duties", config.CheckSeparationOfDuties, "Enable separation-of-duties checks on the reviewers of approved requests")
+	flag.StringVar(&config.SoDTeamTrait, "sod-team-trait", config.SoDTeamTrait, "User trait holding the team name for separation-of-duties checks")
+	flag.StringVar(&config.SoDProdPattern, "sod-prod-pattern", config.SoDProdPattern, "Pattern matching production roles for separation-of-duties checks")
+	flag.IntVar(&config.SoDMaxDailyApprovals, "sod-max-daily-approvals", config.SoDMaxDailyApprovals, "Max approvals by one reviewer for one user within 24h (0 disables)")
+	flag.StringVar(&config.HealthAddr, "health-addr", config.HealthAddr, "Listen address for /healthz and /readyz, e.g. :8080 (disabled if empty)")
+	flag.DurationVar(&config.WatchdogThreshold, "watchdog-threshold", config.WatchdogThreshold, "Act when no evaluation has succeeded for this long (0 disables)")
+	flag.StringVar(&config.WatchdogAction, "watchdog-action", config.WatchdogAction, "Watchdog action: exit or reconnect")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Teleport JIT Access Request Watcher - Polling-based monitoring and policy enforcement\n\n")
-		fmt.Fprintf(os.Stderr, "Required arguments:\n")
+		fmt.Fprintf(os.Stderr, "Required arguments (flag, config file key or environment variable):\n")
 		fmt.Fprintf(os.Stderr, "  -p string\n")
 		fmt.Fprintf(os.Stderr, "        Teleport auth service (e.g., example.teleport.sh:443)\n")
 		fmt.Fprintf(os.Stderr, "  -i string\n")
@@ -683,48 +1906,63 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  # Check every 10 seconds with debug output\n")
 		fmt.Fprintf(os.Stderr, "  %s -p example.teleport.sh:443 -i ./identity -poll-interval=10s -d\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # Run only environment conflict checking with custom patterns\n")
-		fmt.Fprintf(os.Stderr, "  %s -p example.teleport.sh:443 -i ./identity -resource-limit=false -conflict-patterns=test,prod\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -p example.teleport.sh:443 -i ./identity -resource-limit=false -conflict-patterns=test,prod\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Keep the highest-priority request (role label jit-watcher/priority) when policies are violated\n")
+		fmt.Fprintf(os.Stderr, "  %s -p example.teleport.sh:443 -i ./identity -conflict-strategy=keep-highest-priority\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Load settings from a YAML file and show the effective configuration\n")
+		fmt.Fprintf(os.Stderr, "  %s -config ./jit-watcher.yaml -print-config\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Settings are read from defaults, then the -config file (or %sCONFIG),\n", envPrefix)
+		fmt.Fprintf(os.Stderr, "then %s* environment variables, then flags. Later sources win.\n", envPrefix)
 	}
 
 	flag.Parse()
 
-	// Set default conflict patterns if none provided
-	if len(conflictPatterns) == 0 {
-		conflictPatterns = []string{"prod", "research"}
-	}
-	config.ConflictPatterns = conflictPatterns
+	// Remember the flags that were set explicitly, then rebuild the
+	// configuration: defaults < config file < environment < flags
+	explicitFlags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = f.Value.String()
+	})
 
-	// Validate required arguments
-	if config.ProxyServer == "" {
-		fmt.Fprintf(os.Stderr, "Error: Proxy service is required (-p option)\n\n")
-		flag.Usage()
-		os.Exit(1)
+	config = defaultConfig()
+	if configFile != "" {
+		if err := loadConfigFile(configFile, &config); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
 	}
-
-	if config.IdentityFile == "" {
-		fmt.Fprintf(os.Stderr, "Error: Identity file is required (-i option)\n\n")
-		flag.Usage()
-		os.Exit(1)
+	if err := applyEnv(&config); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-
-	// Validate identity file exists
-	if _, err := os.Stat(config.IdentityFile); os.IsNotExist(err) {
-		log.Fatalf("Identity file does not exist: %s", config.IdentityFile)
+	for name, value := range explicitFlags {
+		switch name {
+		case "config", "print-config":
+		case "conflict-patterns":
+			config.ConflictPatterns = conflictPatterns
+		default:
+			flag.Set(name, value)
+		}
 	}
 
-	// Validate max resources
-	if config.MaxResources < 1 {
-		log.Fatalf("Max resources must be a positive integer, got: %d", config.MaxResources)
+	// Print the effective configuration if requested
+	if printConfig {
+		out, err := yaml.Marshal(config)
+		if err != nil {
+			log.Fatalf("Failed to encode configuration: %v", err)
+		}
+		fmt.Print(string(out))
 	}
 
-	// Validate poll interval
-	if config.PollInterval < time.Second {
-		log.Fatalf("Poll interval must be at least 1 second, got: %s", config.PollInterval)
+	// Validate the configuration
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		if !printConfig {
+			flag.Usage()
+		}
+		os.Exit(1)
 	}
 
-	// Validate conflict patterns
-	if config.CheckConflicts && len(config.ConflictPatterns) < 2 {
-		log.Fatalf("Role conflict checking requires at least 2 patterns, got: %v", config.ConflictPatterns)
+	if printConfig {
+		return
 	}
 
 	// Create watcher
@@ -1,22 +1,39 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // -tz works on hosts without a zoneinfo database, e.g. Windows
 
 	"github.com/gravitational/teleport/api/client"
 	"github.com/gravitational/teleport/api/defaults"
 	"github.com/gravitational/teleport/api/profile"
 	"github.com/gravitational/teleport/api/types"
+	apievents "github.com/gravitational/teleport/api/types/events"
+	_ "modernc.org/sqlite"
 )
 
 // Configuration Variables - Modify these to customize the script behavior
@@ -27,17 +44,68 @@ var (
 	identityFilePath = "/path/to/identity"     // Path to identity file (only used if useIdentityFile = true)
 
 	// Time range configuration
-	daysBack = 30 // Number of days back to analyze (default: 30 days)
+	daysBack   = 30        // Number of days back to analyze (default: 30 days)
+	windowMode = "rolling" // Reporting periods without -billing-day: "rolling" (daysBack or -from/-to), "month" or "week" (ISO)
+	timezone   = "UTC"     // IANA timezone in which days, weeks, months and billing cycles start
 
 	// Report configuration
-	reportFormat = "text" // Options: "json" or "text"
+	reportFormat = "text" // Options: "text", "json", "csv" or "html"
+	outputPath   = ""     // Report file path; empty uses the default filename for the format
 
 	// Performance configuration
-	batchSize = 5000 // Number of events to fetch per batch
+	batchSize   = 5000  // Number of events to fetch per batch
+	parallelism = 4     // Number of time shards scanned concurrently
+	shardMode   = "day" // How the window is split for scanning: "day", "cycle" or "none"
+
+	// Detail configuration
+	detailMode = false // Record the distinct resources each user accessed, with first/last seen times
+
+	// Security configuration
+	securityMode = false // Add a security section: failed logins, login methods, MFA and device trust per user
+
+	// Session configuration
+	sessionMode = false // Pair session start and end events to report connected time and session counts per user
+
+	// Time series configuration
+	seriesMode = false // Add daily and weekly distinct-user counts to the JSON report and a CSV
+
+	// Dormant-user configuration
+	dormantDays = 0 // List cluster users with no ZTA/IG activity in this many days (0 disables)
+
+	// Licence limits (0 disables a limit). Passing one exits with status 2
+	// and, if set, posts to alertWebhook.
+	limitZTA     = 0
+	limitIG      = 0
+	limitMWI     = 0
+	alertWebhook = ""
+
+	// Grouping configuration
+	groupBy = "" // "role" or "trait:<name>" to add per-group subtotals (empty disables grouping)
+
+	// Multi-cluster configuration
+	dedupeBy = "username" // Identity key that merges users across clusters: "username", "email" or "sso"
+
+	// Reconciliation configuration
+	reconcilePath = "" // CSV or JSON of per-cycle billed figures to compare the report with (empty disables)
+
+	// Event mapping configuration
+	mappingPath = "" // JSON file mapping event types/codes to report columns (empty uses the built-in mapping)
+
+	// Offline input configuration
+	inputPaths []string // Exported audit event files or directories read instead of SearchEvents (empty reads the cluster)
+
+	// Privacy configuration
+	pseudonymKeyPath = "" // HMAC key file; when set, usernames in every report are replaced by pseudonyms
+	pseudonymMapPath = "" // Where the pseudonym -> username map is written; required with -pseudonymize
+
+	// Checkpoint store configuration
+	storePath = "" // SQLite file holding per-day aggregates between runs (empty disables the store)
 
 	// Output filenames
 	outputFilenameText = "Teleport_Active_Users.txt"
 	outputFilenameJson = "Teleport_Active_Users.json"
+	outputFilenameCSV  = "Teleport_Active_Users.csv"
+	outputFilenameHTML = "Teleport_Active_Users.html"
 )
 
 // UserResourceUsage tracks Zero Trust Access usage for each user
@@ -65,36 +133,113 @@ type UserKindLabel string
 const (
 	UserKindHuman UserKindLabel = "Human"
 	UserKindBot   UserKindLabel = "Bot"
+
+	// UserKindUnknown marks a user whose events never said what it is. It is
+	// resolved to Human or Bot by classifyUsers before reporting.
+	UserKindUnknown UserKindLabel = ""
 )
 
-// classifyUserKind tries to determine whether an event user is human or bot.
-// It defaults to Human if the field is missing/unknown.
-func classifyUserKind(raw map[string]interface{}) UserKindLabel {
-	v, ok := raw["user_kind"]
-	if !ok || v == nil {
+// classifyUserKind reads the kind of an event's user from its identity
+// fields: a bot_name or a USER_KIND_BOT user_kind mean a bot, and
+// USER_KIND_HUMAN means a human. Otherwise the kind is unknown.
+func classifyUserKind(m apievents.UserMetadata) UserKindLabel {
+	switch {
+	case m.UserKind == apievents.UserKind_USER_KIND_BOT || m.BotName != "":
+		return UserKindBot
+	case m.UserKind == apievents.UserKind_USER_KIND_HUMAN:
+		return UserKindHuman
+	default:
+		return UserKindUnknown
+	}
+}
+
+// strongerKind combines two observations of the same user: any bot evidence
+// wins, and an explicit human beats no evidence at all.
+func strongerKind(a, b UserKindLabel) UserKindLabel {
+	if a == UserKindBot || b == UserKindBot {
+		return UserKindBot
+	}
+	if a == UserKindHuman || b == UserKindHuman {
 		return UserKindHuman
 	}
+	return UserKindUnknown
+}
+
+// Sources of a user's final classification, as listed in the report.
+const (
+	kindSourceCluster = "cluster user"   // the user resource, bot label included
+	kindSourceEvent   = "event identity" // user_kind / bot_name on its audit events
+	kindSourcePrefix  = "bot- prefix"    // heuristic: name starts with "bot-"
+	kindSourceDefault = "default"        // heuristic: no evidence, assumed human
+)
+
+// userClassification is the final human/bot decision for one user.
+type userClassification struct {
+	User      string        `json:"user"`
+	Kind      UserKindLabel `json:"kind"`
+	Source    string        `json:"source"`
+	Heuristic bool          `json:"heuristic"`
+}
+
+// classifyUser decides whether a user is a human or a bot. Bot evidence from
+// the cluster or from the user's events wins, then an explicit human from
+// either. Only when neither says anything does it fall back to heuristics.
+// clusterKind is UserKindUnknown when the user resource was not found.
+func classifyUser(user string, clusterKind, eventKind UserKindLabel) userClassification {
+	c := userClassification{User: user}
+	switch {
+	case clusterKind == UserKindBot:
+		c.Kind, c.Source = UserKindBot, kindSourceCluster
+	case eventKind == UserKindBot:
+		c.Kind, c.Source = UserKindBot, kindSourceEvent
+	case clusterKind == UserKindHuman:
+		c.Kind, c.Source = UserKindHuman, kindSourceCluster
+	case eventKind == UserKindHuman:
+		c.Kind, c.Source = UserKindHuman, kindSourceEvent
+	case strings.HasPrefix(user, "bot-"):
+		c.Kind, c.Source, c.Heuristic = UserKindBot, kindSourcePrefix, true
+	default:
+		c.Kind, c.Source, c.Heuristic = UserKindHuman, kindSourceDefault, true
+	}
+	return c
+}
 
-	switch t := v.(type) {
-	case string:
-		s := strings.ToLower(t)
-		// common possibilities: "bot", "human", "USER_KIND_BOT", "USER_KIND_HUMAN", etc.
-		if strings.Contains(s, "bot") {
-			return UserKindBot
+// classifyUsers classifies every user seen in accums once, using the cluster
+// user resources (nil if they could not be listed) and the evidence from all
+// cycles, then rewrites userKind in each accumulator to the final kind.
+func classifyUsers(clusterUsers []types.User, accums []*cycleAccum) map[string]userClassification {
+	cluster := make(map[string]UserKindLabel, len(clusterUsers))
+	for _, u := range clusterUsers {
+		if u.IsBot() {
+			cluster[u.GetName()] = UserKindBot
+		} else {
+			cluster[u.GetName()] = UserKindHuman
 		}
-		if strings.Contains(s, "human") {
-			return UserKindHuman
+	}
+
+	evidence := make(map[string]UserKindLabel)
+	for _, a := range accums {
+		for user, kind := range a.userKind {
+			evidence[user] = strongerKind(evidence[user], kind)
 		}
-		return UserKindHuman
-	case float64:
-		// If user_kind is an enum encoded as a number, we can't be 100% sure here.
-		// Conventionally: 0=unspecified, 1=human, 2=bot (common pattern).
-		if int(t) == 2 {
-			return UserKindBot
+	}
+
+	out := make(map[string]userClassification, len(evidence))
+	for user, kind := range evidence {
+		out[user] = classifyUser(user, cluster[user], kind)
+	}
+	for _, a := range accums {
+		a.applyKinds(out)
+	}
+	return out
+}
+
+// applyKinds rewrites userKind with the final classification of each user.
+func (a *cycleAccum) applyKinds(c map[string]userClassification) {
+	for user := range a.userKind {
+		if cl, ok := c[user]; ok {
+			a.userKind[user] = cl.Kind
 		}
-		return UserKindHuman
-	default:
-		return UserKindHuman
 	}
 }
 
@@ -180,19 +325,20 @@ func daysIn(year int, month time.Month) int {
 	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
 }
 
-// cycleStart returns the anchor-day 00:00 UTC for year/month, clamped to the
-// last day of the month when anchor exceeds that month's length.
+// cycleStart returns the anchor-day 00:00 in the -tz timezone for
+// year/month, clamped to the last day of the month when anchor exceeds that
+// month's length.
 func cycleStart(year int, month time.Month, anchor int) time.Time {
 	d := anchor
 	if last := daysIn(year, month); d > last {
 		d = last
 	}
-	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	return time.Date(year, month, d, 0, 0, 0, 0, reportLocation)
 }
 
 // cycleContaining returns the billing cycle whose half-open window contains t.
 func cycleContaining(t time.Time, anchor int) cycleBounds {
-	t = t.UTC()
+	t = t.In(reportLocation)
 	start := cycleStart(t.Year(), t.Month(), anchor)
 	if t.Before(start) {
 		prevYear, prevMonth := t.Year(), t.Month()-1
@@ -213,20 +359,74 @@ func cycleContaining(t time.Time, anchor int) cycleBounds {
 		End:   end,
 		Label: fmt.Sprintf("%s - %s",
 			start.Format("2 Jan 2006"),
-			end.Add(-24*time.Hour).Format("2 Jan 2006")),
+			end.AddDate(0, 0, -1).Format("2 Jan 2006")),
+	}
+}
+
+// monthContaining returns the calendar month containing t.
+func monthContaining(t time.Time) cycleBounds {
+	c := cycleContaining(t, 1)
+	c.Label = c.Start.Format("January 2006")
+	return c
+}
+
+// weekContaining returns the ISO week, Monday to Sunday, containing t.
+func weekContaining(t time.Time) cycleBounds {
+	day := dayStart(t)
+	start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	end := start.AddDate(0, 0, 7)
+	year, week := start.ISOWeek()
+	return cycleBounds{
+		Start: start,
+		End:   end,
+		Label: fmt.Sprintf("%d-W%02d: %s - %s", year, week,
+			start.Format("2 Jan"), end.AddDate(0, 0, -1).Format("2 Jan 2006")),
+	}
+}
+
+// periodFunc returns how the report is split into periods: billing cycles
+// with -billing-day, else the -window months or weeks. It returns nil for a
+// single rolling window.
+func periodFunc(anchor int) func(time.Time) cycleBounds {
+	switch {
+	case anchor > 0:
+		return func(t time.Time) cycleBounds { return cycleContaining(t, anchor) }
+	case windowMode == "month":
+		return monthContaining
+	case windowMode == "week":
+		return weekContaining
+	}
+	return nil
+}
+
+// periodName describes the periods of a per-period report.
+func periodName() string {
+	switch {
+	case billingDayAnchor > 0:
+		return "billing cycles"
+	case windowMode == "month":
+		return "calendar months"
+	default:
+		return "ISO weeks"
 	}
 }
 
 // lastNCycles returns the cycle containing now plus n fully-completed preceding
 // cycles, oldest-first. The cycle containing now is marked InProgress.
 func lastNCycles(now time.Time, anchor, n int) []cycleBounds {
-	current := cycleContaining(now, anchor)
-	current.InProgress = true
-	out := []cycleBounds{current}
+	out := lastNPeriods(now, n, periodFunc(anchor))
+	out[len(out)-1].InProgress = true
+	return out
+}
+
+// lastNPeriods returns the period containing t plus the n periods before it,
+// oldest-first.
+func lastNPeriods(t time.Time, n int, periodOf func(time.Time) cycleBounds) []cycleBounds {
+	out := []cycleBounds{periodOf(t)}
 	for i := 0; i < n; i++ {
-		// Pick any instant inside the previous cycle (one day before this start).
+		// Pick any instant inside the previous period (one day before this start).
 		prev := out[len(out)-1].Start.Add(-24 * time.Hour)
-		out = append(out, cycleContaining(prev, anchor))
+		out = append(out, periodOf(prev))
 	}
 	// Reverse to oldest-first.
 	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
@@ -235,6 +435,15 @@ func lastNCycles(now time.Time, anchor, n int) []cycleBounds {
 	return out
 }
 
+// periodsBetween returns the periods overlapping [from, to), oldest-first.
+func periodsBetween(from, to time.Time, periodOf func(time.Time) cycleBounds) []cycleBounds {
+	var out []cycleBounds
+	for c := periodOf(from); c.Start.Before(to); c = periodOf(c.End) {
+		out = append(out, c)
+	}
+	return out
+}
+
 // cycleAccum collects per-user activity for a single billing cycle (or, when
 // no -billing-day is set, the whole rolling window).
 type cycleAccum struct {
@@ -242,6 +451,11 @@ type cycleAccum struct {
 	userIGUsage       map[string]*UserIGUsage
 	userKind          map[string]UserKindLabel
 	totalLogins       int
+	unrecognized      map[string]int // event type -> scanned events skipped as undecodable, unmapped or without a user
+	resources         userResources  // only filled in -detail mode
+	signals           userSignals    // only filled in -security mode
+	sessions          sessionSpans   // only filled in -sessions mode
+	connected         userSessions   // per-cycle connected time, set by attributeSessions
 }
 
 func newCycleAccum() *cycleAccum {
@@ -249,292 +463,827 @@ func newCycleAccum() *cycleAccum {
 		userResourceUsage: make(map[string]*UserResourceUsage),
 		userIGUsage:       make(map[string]*UserIGUsage),
 		userKind:          make(map[string]UserKindLabel),
+		unrecognized:      make(map[string]int),
+		resources:         make(userResources),
+		signals:           make(userSignals),
+		sessions:          make(sessionSpans),
+		connected:         make(userSessions),
 	}
 }
 
-// ingest applies one decoded audit event to this accumulator.
-func (a *cycleAccum) ingest(raw map[string]interface{}) {
-	user, ok := raw["user"].(string)
-	if !ok || user == "" {
+// resourceKey identifies one resource a user accessed. Account is the login
+// used on it (OS login, database user or Windows user) where there is one.
+type resourceKey struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Account string `json:"account,omitempty"`
+}
+
+// resourceSeen records when a user accessed a resource.
+type resourceSeen struct {
+	resourceKey
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+// userResources maps a user to the resources it accessed.
+type userResources map[string]map[resourceKey]*resourceSeen
+
+// add records one access, or merges another record of the same resource.
+func (r userResources) add(user string, seen resourceSeen) {
+	if r[user] == nil {
+		r[user] = make(map[resourceKey]*resourceSeen)
+	}
+	existing := r[user][seen.resourceKey]
+	if existing == nil {
+		r[user][seen.resourceKey] = &seen
 		return
 	}
-	kind := classifyUserKind(raw)
-	if existing, ok := a.userKind[user]; !ok {
-		a.userKind[user] = kind
-	} else if existing != UserKindBot && kind == UserKindBot {
-		a.userKind[user] = UserKindBot
+	if seen.FirstSeen.Before(existing.FirstSeen) {
+		existing.FirstSeen = seen.FirstSeen
 	}
-	eventType, ok := raw["event"].(string)
-	if !ok {
-		return
+	if seen.LastSeen.After(existing.LastSeen) {
+		existing.LastSeen = seen.LastSeen
 	}
-	switch eventType {
-	case "user.login":
-		if a.userResourceUsage[user] == nil {
-			a.userResourceUsage[user] = &UserResourceUsage{}
-		}
-		if raw["success"] == true {
-			a.userResourceUsage[user].LoginCount++
-			a.totalLogins++
-		}
-	case "session.start":
-		if a.userResourceUsage[user] == nil {
-			a.userResourceUsage[user] = &UserResourceUsage{}
-		}
-		if kubeCluster, exists := raw["kubernetes_cluster"]; exists && kubeCluster != nil {
-			a.userResourceUsage[user].Kubernetes++
-		} else {
-			a.userResourceUsage[user].SSH++
-		}
-	case "db.session.start":
-		if a.userResourceUsage[user] == nil {
-			a.userResourceUsage[user] = &UserResourceUsage{}
-		}
-		a.userResourceUsage[user].Database++
-	case "app.session.start":
-		if a.userResourceUsage[user] == nil {
-			a.userResourceUsage[user] = &UserResourceUsage{}
-		}
-		a.userResourceUsage[user].Application++
-	case "windows.desktop.session.start":
-		if a.userResourceUsage[user] == nil {
-			a.userResourceUsage[user] = &UserResourceUsage{}
-		}
-		a.userResourceUsage[user].Desktop++
-	case "kube.request":
-		if a.userResourceUsage[user] == nil {
-			a.userResourceUsage[user] = &UserResourceUsage{}
-		}
-		a.userResourceUsage[user].Kubernetes++
-	case "access_request.create":
-		if a.userIGUsage[user] == nil {
-			a.userIGUsage[user] = &UserIGUsage{}
-		}
-		a.userIGUsage[user].AccessRequestsCreated++
-	case "access_request.review":
-		if a.userIGUsage[user] == nil {
-			a.userIGUsage[user] = &UserIGUsage{}
-		}
-		a.userIGUsage[user].AccessRequestsReviewed++
-	case "access_list.member.create", "access_list.member.update":
-		if a.userIGUsage[user] == nil {
-			a.userIGUsage[user] = &UserIGUsage{}
-		}
-		a.userIGUsage[user].AccessListsMemberships++
-	case "access_list.review":
-		if a.userIGUsage[user] == nil {
-			a.userIGUsage[user] = &UserIGUsage{}
+	existing.Count += seen.Count
+}
+
+// sorted returns a user's resources ordered by kind, name and account.
+func (r userResources) sorted(user string) []*resourceSeen {
+	out := make([]*resourceSeen, 0, len(r[user]))
+	for _, seen := range r[user] {
+		out = append(out, seen)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].resourceKey, out[j].resourceKey
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
 		}
-		a.userIGUsage[user].AccessListsReviewed++
-	case "saml.idp.auth":
-		if a.userIGUsage[user] == nil {
-			a.userIGUsage[user] = &UserIGUsage{}
+		if a.Name != b.Name {
+			return a.Name < b.Name
 		}
-		a.userIGUsage[user].SAMLIDPSessions++
+		return a.Account < b.Account
+	})
+	return out
+}
+
+// Security signals recorded per user in -security mode. They are counted
+// apart from the MAU columns and never change the MAU numbers.
+const (
+	signalLogin      = "login"        // user.login outcome: success or failed
+	signalMethod     = "login_method" // user.login method: local, saml, oidc, github, ...
+	signalMFA        = "mfa_device"   // type of the MFA device used to log in
+	signalSessionMFA = "session_mfa"  // sessions started with per-session MFA
+	signalDevice     = "device"       // trusted or untrusted device, over every tracked event
+)
+
+// userSignals counts security signals: user -> signal -> value -> events.
+type userSignals map[string]map[string]map[string]int
+
+// add counts n events with a signal value for user.
+func (s userSignals) add(user, signal, value string, n int) {
+	if s[user] == nil {
+		s[user] = make(map[string]map[string]int)
+	}
+	if s[user][signal] == nil {
+		s[user][signal] = make(map[string]int)
 	}
+	s[user][signal][value] += n
 }
 
-// cycleSummary holds the filtered + counted view of one accumulator.
-type cycleSummary struct {
-	ztaMAUAll     map[string]*UserResourceUsage
-	igMAUAll      map[string]*UserIGUsage
-	ztaHumanCount int
-	igHumanCount  int
-	mwiBotCount   int
+// sessionEvents are the events -sessions pairs by session ID, with the
+// category their sessions are reported under. SSH sessions into a
+// Kubernetes cluster are reported as kubernetes.
+var sessionEvents = map[string]struct {
+	category string
+	end      bool
+}{
+	"session.start":                 {"ssh", false},
+	"session.end":                   {"ssh", true},
+	"db.session.start":              {"database", false},
+	"db.session.end":                {"database", true},
+	"app.session.start":             {"application", false},
+	"app.session.end":               {"application", true},
+	"windows.desktop.session.start": {"desktop", false},
+	"windows.desktop.session.end":   {"desktop", true},
 }
 
-func (a *cycleAccum) summarize() cycleSummary {
-	ztaMAUAll := make(map[string]*UserResourceUsage)
-	for user, usage := range a.userResourceUsage {
-		if usage.SSH > 0 || usage.Kubernetes > 0 || usage.Database > 0 ||
-			usage.Application > 0 || usage.Desktop > 0 {
-			ztaMAUAll[user] = usage
-		}
+// sessionSpan is what has been seen of one session. Start and End stay zero
+// until an event for them is seen; the end events of SSH, database and
+// desktop sessions also carry the start time.
+type sessionSpan struct {
+	User     string
+	Category string
+	Start    time.Time
+	End      time.Time
+}
+
+// sessionSpans holds the sessions seen in -sessions mode, by session ID.
+type sessionSpans map[string]*sessionSpan
+
+// add merges a partial record of session id into s.
+func (s sessionSpans) add(id string, span sessionSpan) {
+	existing := s[id]
+	if existing == nil {
+		s[id] = &span
+		return
 	}
-	igMAUAll := make(map[string]*UserIGUsage)
-	for user, usage := range a.userIGUsage {
-		if usage.AccessRequestsCreated > 0 || usage.AccessRequestsReviewed > 0 ||
-			usage.AccessListsMemberships > 0 || usage.AccessListsReviewed > 0 ||
-			usage.SAMLIDPSessions > 0 {
-			igMAUAll[user] = usage
-		}
+	if !span.Start.IsZero() && (existing.Start.IsZero() || span.Start.Before(existing.Start)) {
+		existing.Start = span.Start
 	}
-	ztaHumanCount := 0
-	igHumanCount := 0
-	botSet := make(map[string]struct{})
-	for user := range ztaMAUAll {
-		if a.userKind[user] == UserKindBot {
-			botSet[user] = struct{}{}
-		} else {
-			ztaHumanCount++
-		}
+	if span.End.After(existing.End) {
+		existing.End = span.End
 	}
-	for user := range igMAUAll {
-		if a.userKind[user] == UserKindBot {
-			botSet[user] = struct{}{}
-		} else {
-			igHumanCount++
-		}
+}
+
+// pair records the session start and end events of -sessions mode. They are
+// matched by session ID once every day of the window has been merged.
+// Failed database and desktop connections open no session.
+func (a *cycleAccum) pair(e *eventFields) {
+	ev, ok := sessionEvents[e.Type]
+	if !ok || e.SessionID == "" || e.User == "" {
+		return
 	}
-	return cycleSummary{
-		ztaMAUAll:     ztaMAUAll,
-		igMAUAll:      igMAUAll,
-		ztaHumanCount: ztaHumanCount,
-		igHumanCount:  igHumanCount,
-		mwiBotCount:   len(botSet),
+	span := sessionSpan{User: e.User, Category: ev.category}
+	if ev.category == "ssh" && e.KubernetesCluster != "" {
+		span.Category = "kubernetes"
+	}
+	switch {
+	case ev.end:
+		span.Start, span.End = e.SessionStart, e.SessionStop
+		if span.End.IsZero() {
+			span.End = e.GetTime()
+		}
+	case (ev.category == "database" || ev.category == "desktop") && !e.Success:
+		return
+	default:
+		span.Start = e.GetTime()
 	}
+	a.sessions.add(e.SessionID, span)
 }
 
-func main() {
-	// Command-line flags
-	proxyFlag := flag.String(
-		"proxy",
-		"",
-		"Teleport proxy address, e.g. teleport.example.com:443 (required)",
-	)
-
-	identityFileFlag := flag.String(
-		"identity_file",
-		"",
-		"Path to Teleport identity file (optional - enables use of an identity file instead of ambient tsh credentials)",
-	)
+// sessionUsage is a user's sessions of one category in a cycle.
+type sessionUsage struct {
+	Sessions  int           // sessions that started in the cycle
+	Open      int           // of those, sessions with no end event in the window
+	Connected time.Duration // connected time within the cycle
+}
 
-	formatFlag := flag.String(
-		"format",
-		"text",
-		"Output file type - text or json",
-	)
+// userSessions holds connected time: user -> category -> usage.
+type userSessions map[string]map[string]*sessionUsage
 
-	billingDayFlag := flag.Int(
-		"billing-day",
-		0,
-		"Billing cycle anchor day (1-31). When set, the report is aligned to Teleport billing cycles instead of a rolling daysBack window.",
-	)
+// add adds u to the usage of user in category.
+func (s userSessions) add(user, category string, u sessionUsage) {
+	if s[user] == nil {
+		s[user] = make(map[string]*sessionUsage)
+	}
+	if s[user][category] == nil {
+		s[user][category] = &sessionUsage{}
+	}
+	s[user][category].Sessions += u.Sessions
+	s[user][category].Open += u.Open
+	s[user][category].Connected += u.Connected
+}
 
-	cyclesFlag := flag.Int(
-		"cycles",
-		3,
-		"Number of completed cycles to include alongside the in-progress cycle (only used with -billing-day).",
-	)
+// openSessionLimit bounds the time counted for a session when one of its
+// ends is unknown: a session with no end event is counted until the end of
+// the window, and one whose start is unknown from the start of the window,
+// but never for longer than this. It keeps a lost end event from counting
+// weeks of connected time.
+const openSessionLimit = 24 * time.Hour
 
-	flag.Parse()
+// attributeSessions matches the session starts and ends seen in [from, to)
+// and adds each session's connected time to the cycles it overlaps, clipped
+// to the window. A session counts once, in the cycle where it starts or, if
+// it started earlier, the first cycle.
+func attributeSessions(spans sessionSpans, cycles []cycleBounds, accums []*cycleAccum, from, to time.Time) {
+	for _, id := range sortedKeys(spans) {
+		s := spans[id]
+		start, end, open := s.Start, s.End, false
+		switch {
+		case end.IsZero():
+			// Still running at the end of the window, or the end was lost.
+			open = true
+			end = to
+			if end.Sub(start) > openSessionLimit {
+				end = start.Add(openSessionLimit)
+			}
+		case start.IsZero():
+			// Started before the window; the end event has no start time.
+			start = from
+			if end.Sub(start) > openSessionLimit {
+				start = end.Add(-openSessionLimit)
+			}
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.Before(start) {
+			continue
+		}
 
-	teleportProxyURL = *proxyFlag
-	if teleportProxyURL == "" {
-		log.Fatalf("-proxy is required (e.g. -proxy teleport.example.com:443). Run with -h for usage.")
-	}
-	canonicalProxy, err := preflightProxy(teleportProxyURL)
-	if err != nil {
-		log.Fatalf("%v", err)
+		for i, c := range cycles {
+			lo, hi := start, end
+			if c.Start.After(lo) {
+				lo = c.Start
+			}
+			if c.End.Before(hi) {
+				hi = c.End
+			}
+			// Like the final shard, the last cycle keeps a session that
+			// starts exactly at the end of the window.
+			var u sessionUsage
+			last := i == len(cycles)-1 && start.Equal(c.End)
+			if !start.Before(c.Start) && (start.Before(c.End) || last) {
+				u.Sessions = 1
+				if open {
+					u.Open = 1
+				}
+			}
+			if hi.After(lo) {
+				u.Connected = hi.Sub(lo)
+			}
+			if u.Sessions > 0 || u.Connected > 0 {
+				accums[i].connected.add(s.User, s.Category, u)
+			}
+		}
 	}
-	teleportProxyURL = canonicalProxy
+}
 
-	// Output format handling
-	reportFormat = strings.ToLower(strings.TrimSpace(*formatFlag))
-	if reportFormat != "text" && reportFormat != "json" {
-		log.Fatalf("invalid -format %q (expected text or json)", reportFormat)
+// observe records the security signals of an event. Failed logins are
+// observed too, even though they never make a user active.
+func (a *cycleAccum) observe(e *eventFields) {
+	if e.User == "" {
+		return
 	}
-
-	if *identityFileFlag != "" {
-		useIdentityFile = true
-		identityFilePath = *identityFileFlag
-		// Validation
-		if _, err := os.Stat(identityFilePath); err != nil {
-			log.Fatalf("identity file not accessible: %v", err)
+	if e.Type == "user.login" {
+		if e.Success {
+			a.signals.add(e.User, signalLogin, "success", 1)
+		} else {
+			a.signals.add(e.User, signalLogin, "failed", 1)
 		}
-	} else {
-		if err := preflightTshProfile(teleportProxyURL); err != nil {
-			log.Fatalf("%v", err)
+		if e.Method != "" {
+			a.signals.add(e.User, signalMethod, e.Method, 1)
+		}
+		if e.MFADevice != nil {
+			a.signals.add(e.User, signalMFA, firstNonEmpty(e.MFADevice.DeviceType, "unknown"), 1)
 		}
 	}
-
-	billingDay := *billingDayFlag
-	if billingDay < 0 || billingDay > 31 {
-		log.Fatalf("invalid -billing-day %d (expected 1-31, or 0 to disable)", billingDay)
+	if e.WithMFA != "" {
+		a.signals.add(e.User, signalSessionMFA, "", 1)
 	}
-	billingDayAnchor = billingDay
-	cyclesCount := *cyclesFlag
-	if cyclesCount < 0 {
-		log.Fatalf("invalid -cycles %d (must be >= 0)", cyclesCount)
+	if e.TrustedDevice != nil {
+		a.signals.add(e.User, signalDevice, "trusted", 1)
+	} else {
+		a.signals.add(e.User, signalDevice, "untrusted", 1)
 	}
+}
 
-	ctx := context.Background()
+// touch records that user accessed a resource at t. It is a no-op unless
+// -detail is set.
+func (a *cycleAccum) touch(user, kind, name, account string, t time.Time) {
+	if !detailMode {
+		return
+	}
+	a.resources.add(user, resourceSeen{
+		resourceKey: resourceKey{Kind: kind, Name: name, Account: account},
+		FirstSeen:   t.UTC(),
+		LastSeen:    t.UTC(),
+		Count:       1,
+	})
+}
 
-	// Build credentials based on configuration
-	var credentials []client.Credentials
-	if useIdentityFile {
-		credentials = []client.Credentials{
-			client.LoadIdentityFile(identityFilePath),
-		}
-	} else {
-		credentials = []client.Credentials{
-			client.LoadProfile("", ""),
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
 		}
 	}
+	return ""
+}
 
-	clt, err := client.New(ctx, client.Config{
-		Addrs:       []string{teleportProxyURL},
-		Credentials: credentials,
-	})
-	if err != nil {
-		log.Fatalf("failed to create client: %v", err)
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var out []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
-	defer clt.Close()
+	return out
+}
 
-	// Define the time range and per-cycle accumulators.
-	var (
-		fromUTC, toUTC time.Time
-		cycles         []cycleBounds
-		accums         []*cycleAccum
-		single         *cycleAccum
-	)
-	if billingDay > 0 {
-		now := time.Now().UTC()
-		cycles = lastNCycles(now, billingDay, cyclesCount)
-		accums = make([]*cycleAccum, len(cycles))
-		for i := range accums {
-			accums[i] = newCycleAccum()
-		}
-		fromUTC = cycles[0].Start
-		toUTC = now
-		log.Printf("[INFO] Billing-cycle mode: anchor=%d, %d cycle(s) from %s to %s",
-			billingDay, len(cycles), fromUTC.Format("2006-01-02"), toUTC.Format("2006-01-02"))
-		if now.Sub(fromUTC) > 90*24*time.Hour {
-			log.Printf("[WARN] Requested window spans %.0f days; older cycles may be empty due to audit log retention.",
-				now.Sub(fromUTC).Hours()/24)
-		}
-	} else {
-		fromUTC = time.Now().AddDate(0, 0, -daysBack)
-		toUTC = time.Now()
-		single = newCycleAccum()
+// Report columns an event can be counted towards. The category, ZTA or IG,
+// follows from the column.
+var (
+	ztaColumns = []string{"login_count", "ssh", "kubernetes", "database", "application", "desktop"}
+	igColumns  = []string{
+		"access_requests_created", "access_requests_reviewed", "access_lists_memberships",
+		"access_lists_reviewed", "saml_idp_sessions",
 	}
+)
 
-	nextKey := ""
+// eventRule counts the events of one type towards a report column. With a
+// code, only events with that code are counted; a rule with a code wins over
+// one without for the same event type.
+type eventRule struct {
+	Event  string `json:"event"`
+	Code   string `json:"code,omitempty"`
+	Column string `json:"column"`
+}
 
-	// Event types to track for both ZTA MAU and IG MAU
-	eventTypes := []string{
-		// ZTA MAU events (resource access)
-		"user.login",
-		"session.start",
-		"db.session.start",
-		"app.session.start",
-		"windows.desktop.session.start",
-		"kube.request",
-		// IG MAU events (identity governance)
-		"access_request.create",
-		"access_request.review",
-		"access_list.member.create",
-		"access_list.member.update",
-		"access_list.review",
-		"saml.idp.auth",
+// defaultEventRules is the built-in mapping, used when -mapping is not set.
+var defaultEventRules = []eventRule{
+	// ZTA MAU events (resource access)
+	{Event: "user.login", Column: "login_count"},
+	{Event: "session.start", Column: "ssh"},
+	{Event: "db.session.start", Column: "database"},
+	{Event: "app.session.start", Column: "application"},
+	{Event: "windows.desktop.session.start", Column: "desktop"},
+	{Event: "kube.request", Column: "kubernetes"},
+	// IG MAU events (identity governance)
+	{Event: "access_request.create", Column: "access_requests_created"},
+	{Event: "access_request.review", Column: "access_requests_reviewed"},
+	{Event: "access_list.member.create", Column: "access_lists_memberships"},
+	{Event: "access_list.member.update", Column: "access_lists_memberships"},
+	{Event: "access_list.review", Column: "access_lists_reviewed"},
+	{Event: "saml.idp.auth", Column: "saml_idp_sessions"},
+}
+
+// eventMapping indexes rules by event type, then by code ("" for any code).
+type eventMapping map[string]map[string]string
+
+// newEventMapping validates rules and indexes them.
+func newEventMapping(rules []eventRule) (eventMapping, error) {
+	columns := make(map[string]bool)
+	for _, c := range append(append([]string{}, ztaColumns...), igColumns...) {
+		columns[c] = true
 	}
 
-	for {
-		log.Println("Fetching batch of events...")
-		rawEvents, newNextKey, err := clt.SearchEvents(
-			ctx,
-			fromUTC,
-			toUTC,
+	m := make(eventMapping)
+	for i, r := range rules {
+		if r.Event == "" {
+			return nil, fmt.Errorf("rule %d: missing event", i+1)
+		}
+		if !columns[r.Column] {
+			return nil, fmt.Errorf("rule %d (%s): unknown column %q", i+1, r.Event, r.Column)
+		}
+		if m[r.Event] == nil {
+			m[r.Event] = make(map[string]string)
+		}
+		if prev, ok := m[r.Event][r.Code]; ok {
+			return nil, fmt.Errorf("rule %d: %s code %q is already counted as %s", i+1, r.Event, r.Code, prev)
+		}
+		m[r.Event][r.Code] = r.Column
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("no rules")
+	}
+	return m, nil
+}
+
+// loadEventMapping reads a -mapping file: a JSON object with a "rules" list.
+func loadEventMapping(path string) (eventMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Rules []eventRule `json:"rules"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, err
+	}
+	return newEventMapping(config.Rules)
+}
+
+// column returns the column an event counts towards, if any.
+func (m eventMapping) column(eventType, code string) (string, bool) {
+	codes := m[eventType]
+	if column, ok := codes[code]; ok && code != "" {
+		return column, true
+	}
+	column, ok := codes[""]
+	return column, ok
+}
+
+// eventTypes returns the mapped event types, sorted, for SearchEvents.
+func (m eventMapping) eventTypes() []string {
+	return sortedKeys(m)
+}
+
+// rules lists the mapping in the -mapping file format, sorted by event type
+// and code.
+func (m eventMapping) rules() []eventRule {
+	var out []eventRule
+	for _, eventType := range sortedKeys(m) {
+		for _, code := range sortedKeys(m[eventType]) {
+			out = append(out, eventRule{Event: eventType, Code: code, Column: m[eventType][code]})
+		}
+	}
+	return out
+}
+
+// mapping is the active event mapping, replaced by -mapping.
+var mapping = func() eventMapping {
+	m, err := newEventMapping(defaultEventRules)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}()
+
+// eventFields is the part of an audit event the tracker reads. Events are
+// reduced to it before counting, so that any event type, including ones this
+// version of the API has no struct for, can be mapped to a column.
+type eventFields struct {
+	apievents.Metadata
+	apievents.UserMetadata
+	Success           bool                         `json:"success"`
+	Method            string                       `json:"method,omitempty"`
+	MFADevice         *apievents.MFADeviceMetadata `json:"mfa_device,omitempty"`
+	WithMFA           string                       `json:"with_mfa,omitempty"`
+	SessionID         string                       `json:"sid,omitempty"`
+	SessionStart      time.Time                    `json:"session_start,omitempty"`
+	SessionStop       time.Time                    `json:"session_stop,omitempty"`
+	ServerID          string                       `json:"server_id,omitempty"`
+	ServerHostname    string                       `json:"server_hostname,omitempty"`
+	KubernetesCluster string                       `json:"kubernetes_cluster,omitempty"`
+	DatabaseService   string                       `json:"db_service,omitempty"`
+	DatabaseName      string                       `json:"db_name,omitempty"`
+	DatabaseUser      string                       `json:"db_user,omitempty"`
+	AppName           string                       `json:"app_name,omitempty"`
+	PublicAddr        string                       `json:"public_addr,omitempty"`
+	DesktopName       string                       `json:"desktop_name,omitempty"`
+	DesktopAddr       string                       `json:"desktop_addr,omitempty"`
+	WindowsUser       string                       `json:"windows_user,omitempty"`
+}
+
+// fieldsOf reduces an event to eventFields. The structs of the built-in
+// mapping are copied directly; any other event goes through its JSON form.
+func fieldsOf(event apievents.AuditEvent) (*eventFields, error) {
+	switch e := event.(type) {
+	case *apievents.UserLogin:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, Success: e.Success,
+			Method: e.Method, MFADevice: e.MFADevice}, nil
+	case *apievents.SessionStart:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, WithMFA: e.WithMFA, SessionID: e.SessionID,
+			ServerID: e.ServerID, ServerHostname: e.ServerHostname, KubernetesCluster: e.KubernetesCluster}, nil
+	case *apievents.DatabaseSessionStart:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, Success: e.Success, WithMFA: e.WithMFA,
+			SessionID: e.SessionID, DatabaseService: e.DatabaseService, DatabaseName: e.DatabaseName, DatabaseUser: e.DatabaseUser}, nil
+	case *apievents.AppSessionStart:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, WithMFA: e.WithMFA, SessionID: e.SessionID,
+			AppName: e.AppName, PublicAddr: e.PublicAddr}, nil
+	case *apievents.WindowsDesktopSessionStart:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, Success: e.Success, WithMFA: e.WithMFA,
+			SessionID: e.SessionID, DesktopName: e.DesktopName, DesktopAddr: e.DesktopAddr, WindowsUser: e.WindowsUser}, nil
+	case *apievents.SessionEnd:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, SessionID: e.SessionID,
+			SessionStart: e.StartTime, SessionStop: e.EndTime, KubernetesCluster: e.KubernetesCluster}, nil
+	case *apievents.DatabaseSessionEnd:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, SessionID: e.SessionID,
+			SessionStart: e.StartTime, SessionStop: e.EndTime}, nil
+	case *apievents.AppSessionEnd:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, SessionID: e.SessionID}, nil
+	case *apievents.WindowsDesktopSessionEnd:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, SessionID: e.SessionID,
+			SessionStart: e.StartTime, SessionStop: e.EndTime}, nil
+	case *apievents.KubeRequest:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, WithMFA: e.WithMFA,
+			KubernetesCluster: e.KubernetesCluster}, nil
+	case *apievents.AccessRequestCreate:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata}, nil
+	case *apievents.AccessListMemberCreate:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata}, nil
+	case *apievents.AccessListMemberUpdate:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata}, nil
+	case *apievents.AccessListReview:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, Success: e.Success}, nil
+	case *apievents.SAMLIdPAuthAttempt:
+		return &eventFields{Metadata: e.Metadata, UserMetadata: e.UserMetadata, Success: e.Success}, nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	f := &eventFields{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ingest applies one audit event to this accumulator. Events that cannot be
+// reduced to eventFields are counted as unrecognised.
+func (a *cycleAccum) ingest(event apievents.AuditEvent) {
+	// Types the vendored API cannot decode arrive as Unknown, typed "unknown".
+	if u, ok := event.(*apievents.Unknown); ok {
+		a.unrecognized[firstNonEmpty(u.UnknownType, u.GetType())]++
+		return
+	}
+	f, err := fieldsOf(event)
+	if err != nil {
+		a.unrecognized[event.GetType()]++
+		return
+	}
+	a.ingestFields(f)
+}
+
+// ingestFields applies one event, already reduced to eventFields, to this
+// accumulator. Exported audit logs are decoded straight into eventFields.
+// Events that no part of the run uses, such as a code the mapping does not
+// count, and events without a user are counted as unrecognised.
+func (a *cycleAccum) ingestFields(f *eventFields) {
+	_, mapped := mapping.column(f.Type, f.Code)
+	_, paired := sessionEvents[f.Type]
+	login := f.Type == "user.login"
+	if f.User == "" || !(mapped || securityMode && login || sessionMode && paired) {
+		a.unrecognized[f.Type]++
+		return
+	}
+
+	// Only events the mapping counts, and logins, carry security signals:
+	// session ends scanned for -sessions would count each session twice.
+	if securityMode && (mapped || login) {
+		a.observe(f)
+	}
+	if sessionMode {
+		a.pair(f)
+	}
+	a.apply(f)
+}
+
+// apply counts an event towards the column the mapping gives it. Events the
+// mapping does not cover, or without a user, are ignored.
+func (a *cycleAccum) apply(e *eventFields) {
+	column, ok := mapping.column(e.Type, e.Code)
+	if !ok {
+		return
+	}
+	user, ok := a.track(e.UserMetadata)
+	if !ok {
+		return
+	}
+
+	switch column {
+	case "login_count":
+		usage := a.zta(user)
+		if e.Success {
+			usage.LoginCount++
+			a.totalLogins++
+		}
+	case "ssh":
+		// Kubernetes exec sessions are reported as SSH session events.
+		if e.KubernetesCluster != "" {
+			a.zta(user).Kubernetes++
+			a.touch(user, "kubernetes", e.KubernetesCluster, "", e.GetTime())
+		} else {
+			a.zta(user).SSH++
+			a.touch(user, "ssh", firstNonEmpty(e.ServerHostname, e.ServerID), e.Login, e.GetTime())
+		}
+	case "kubernetes":
+		a.zta(user).Kubernetes++
+		a.touch(user, "kubernetes", e.KubernetesCluster, "", e.GetTime())
+	case "database":
+		a.zta(user).Database++
+		name := e.DatabaseService
+		if e.DatabaseName != "" {
+			name += "/" + e.DatabaseName
+		}
+		a.touch(user, "database", name, e.DatabaseUser, e.GetTime())
+	case "application":
+		a.zta(user).Application++
+		a.touch(user, "application", firstNonEmpty(e.AppName, e.PublicAddr), "", e.GetTime())
+	case "desktop":
+		a.zta(user).Desktop++
+		a.touch(user, "desktop", firstNonEmpty(e.DesktopName, e.DesktopAddr), e.WindowsUser, e.GetTime())
+	case "access_requests_created":
+		a.ig(user).AccessRequestsCreated++
+	case "access_requests_reviewed":
+		a.ig(user).AccessRequestsReviewed++
+	case "access_lists_memberships":
+		a.ig(user).AccessListsMemberships++
+	case "access_lists_reviewed":
+		a.ig(user).AccessListsReviewed++
+	case "saml_idp_sessions":
+		a.ig(user).SAMLIDPSessions++
+	}
+}
+
+// track records the kind of the event's user and returns the user name. ok
+// is false for events without a user.
+func (a *cycleAccum) track(m apievents.UserMetadata) (string, bool) {
+	if m.User == "" {
+		return "", false
+	}
+	a.userKind[m.User] = strongerKind(a.userKind[m.User], classifyUserKind(m))
+	return m.User, true
+}
+
+// zta returns the user's ZTA usage, creating it if needed.
+func (a *cycleAccum) zta(user string) *UserResourceUsage {
+	if a.userResourceUsage[user] == nil {
+		a.userResourceUsage[user] = &UserResourceUsage{}
+	}
+	return a.userResourceUsage[user]
+}
+
+// ig returns the user's IG usage, creating it if needed.
+func (a *cycleAccum) ig(user string) *UserIGUsage {
+	if a.userIGUsage[user] == nil {
+		a.userIGUsage[user] = &UserIGUsage{}
+	}
+	return a.userIGUsage[user]
+}
+
+// merge adds the activity collected in o to a. Counts are summed and kinds
+// are combined with strongerKind, so the result does not depend on the order
+// in which accumulators are merged.
+func (a *cycleAccum) merge(o *cycleAccum) {
+	a.mergeAs(o, func(user string) string { return user })
+}
+
+// mergeAs is merge with every user of o renamed by key first. Users of o
+// that map to the same key are summed into one.
+func (a *cycleAccum) mergeAs(o *cycleAccum, key func(user string) string) {
+	for user, usage := range o.userResourceUsage {
+		a.zta(key(user)).add(usage)
+	}
+	for user, usage := range o.userIGUsage {
+		a.ig(key(user)).add(usage)
+	}
+	for user, kind := range o.userKind {
+		k := key(user)
+		a.userKind[k] = strongerKind(a.userKind[k], kind)
+	}
+	a.totalLogins += o.totalLogins
+	for eventType, n := range o.unrecognized {
+		a.unrecognized[eventType] += n
+	}
+	for user, seen := range o.resources {
+		for _, r := range seen {
+			a.resources.add(key(user), *r)
+		}
+	}
+	for user, signals := range o.signals {
+		for signal, values := range signals {
+			for value, n := range values {
+				a.signals.add(key(user), signal, value, n)
+			}
+		}
+	}
+	for id, span := range o.sessions {
+		a.sessions.add(id, sessionSpan{User: key(span.User), Category: span.Category, Start: span.Start, End: span.End})
+	}
+	for user, categories := range o.connected {
+		for category, u := range categories {
+			a.connected.add(key(user), category, *u)
+		}
+	}
+}
+
+// add sums the counters of o into u.
+func (u *UserResourceUsage) add(o *UserResourceUsage) {
+	u.LoginCount += o.LoginCount
+	u.SSH += o.SSH
+	u.Kubernetes += o.Kubernetes
+	u.Database += o.Database
+	u.Application += o.Application
+	u.Desktop += o.Desktop
+}
+
+// add sums the counters of o into u.
+func (u *UserIGUsage) add(o *UserIGUsage) {
+	u.AccessRequestsCreated += o.AccessRequestsCreated
+	u.AccessRequestsReviewed += o.AccessRequestsReviewed
+	u.AccessListsMemberships += o.AccessListsMemberships
+	u.AccessListsReviewed += o.AccessListsReviewed
+	u.SAMLIDPSessions += o.SAMLIDPSessions
+}
+
+// dayStart returns 00:00 in the -tz timezone of the day containing t.
+func dayStart(t time.Time) time.Time {
+	t = t.In(reportLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, reportLocation)
+}
+
+// dailyAccums holds one accumulator per -tz day, keyed by dayStart.
+type dailyAccums map[time.Time]*cycleAccum
+
+// day returns the accumulator for the day containing t, creating it if needed.
+func (d dailyAccums) day(t time.Time) *cycleAccum {
+	key := dayStart(t)
+	if d[key] == nil {
+		d[key] = newCycleAccum()
+	}
+	return d[key]
+}
+
+// sortedDays returns the day keys in chronological order.
+func (d dailyAccums) sortedDays() []time.Time {
+	days := make([]time.Time, 0, len(d))
+	for day := range d {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// accums returns the day accumulators in chronological order.
+func (d dailyAccums) accums() []*cycleAccum {
+	out := make([]*cycleAccum, 0, len(d))
+	for _, day := range d.sortedDays() {
+		out = append(out, d[day])
+	}
+	return out
+}
+
+// merge folds the days of o into d.
+func (d dailyAccums) merge(o dailyAccums) {
+	for _, day := range o.sortedDays() {
+		d.day(day).merge(o[day])
+	}
+}
+
+// fold merges every day in [from, to) into a single accumulator. Cycle
+// bounds fall on -tz midnight, so each day belongs to exactly one cycle.
+func (d dailyAccums) fold(from, to time.Time) *cycleAccum {
+	out := newCycleAccum()
+	for _, day := range d.sortedDays() {
+		if !day.Before(dayStart(from)) && day.Before(to) {
+			out.merge(d[day])
+		}
+	}
+	return out
+}
+
+// timeShard is one slice of the scan window, searched by a single worker.
+type timeShard struct {
+	From time.Time
+	To   time.Time
+	Last bool // the final shard also keeps events stamped exactly at To
+}
+
+// contains reports whether t falls inside the shard. Shards are half-open
+// so that an event on a boundary is counted by exactly one shard.
+func (s timeShard) contains(t time.Time) bool {
+	if t.Before(s.From) {
+		return false
+	}
+	if s.Last {
+		return !t.After(s.To)
+	}
+	return t.Before(s.To)
+}
+
+// shardWindow splits [from, to] into shards: one per -tz day ("day"), one per
+// billing cycle ("cycle"), or a single shard ("none").
+func shardWindow(from, to time.Time, mode string, cycles []cycleBounds) []timeShard {
+	var bounds []time.Time
+	switch mode {
+	case "day":
+		for t := dayStart(from).AddDate(0, 0, 1); t.Before(to); t = t.AddDate(0, 0, 1) {
+			bounds = append(bounds, t)
+		}
+	case "cycle":
+		for _, c := range cycles {
+			if c.Start.After(from) && c.Start.Before(to) {
+				bounds = append(bounds, c.Start)
+			}
+		}
+	}
+
+	shards := make([]timeShard, 0, len(bounds)+1)
+	start := from
+	for _, b := range bounds {
+		shards = append(shards, timeShard{From: start, To: b})
+		start = b
+	}
+	return append(shards, timeShard{From: start, To: to, Last: true})
+}
+
+// scanShard pages through SearchEvents for one shard and ingests each event
+// into the accumulator of the -tz day it happened on.
+func scanShard(ctx context.Context, clt *client.Client, shard timeShard, eventTypes []string) (dailyAccums, error) {
+	days := dailyAccums{}
+	nextKey := ""
+
+	for {
+		log.Printf("Fetching batch of events for %s - %s...",
+			shard.From.Format(time.RFC3339), shard.To.Format(time.RFC3339))
+		rawEvents, newNextKey, err := clt.SearchEvents(
+			ctx,
+			shard.From,
+			shard.To,
 			defaults.Namespace,
 			eventTypes,
 			batchSize,
@@ -542,64 +1291,3114 @@ func main() {
 			nextKey,
 		)
 		if err != nil {
-			log.Fatalf("Failed to fetch events: %v", err)
+			return nil, fmt.Errorf("failed to fetch events: %w", err)
+		}
+		if len(rawEvents) == 0 {
+			break
+		}
+
+		for _, event := range rawEvents {
+			et := event.GetTime().UTC()
+			if !shard.contains(et) {
+				continue
+			}
+			days.day(et).ingest(event)
+		}
+
+		// If no next page, break
+		if newNextKey == "" || newNextKey == nextKey {
+			break
+		}
+		nextKey = newNextKey
+	}
+
+	return days, nil
+}
+
+// scanShards scans the shards with at most parallel concurrent workers. Each
+// shard fills its own per-day accumulators, which are merged in shard order
+// once every worker is done, so the result matches a sequential scan.
+func scanShards(ctx context.Context, clt *client.Client, shards []timeShard, eventTypes []string, parallel int) (dailyAccums, error) {
+	results := make([]dailyAccums, len(shards))
+	errs := make([]error, len(shards))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = scanShard(ctx, clt, shard, eventTypes)
+		}()
+	}
+	wg.Wait()
+
+	merged := dailyAccums{}
+	for i, shard := range shards {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("shard %s - %s: %w",
+				shard.From.Format(time.RFC3339), shard.To.Format(time.RFC3339), errs[i])
+		}
+		merged.merge(results[i])
+	}
+	return merged, nil
+}
+
+// scanWindow shards [from, to] and scans it. With inclusiveEnd false the last
+// shard is half-open too, so consecutive windows never overlap.
+func scanWindow(ctx context.Context, clt *client.Client, from, to time.Time, cycles []cycleBounds, eventTypes []string, inclusiveEnd bool) (dailyAccums, error) {
+	shards := shardWindow(from, to, shardMode, cycles)
+	shards[len(shards)-1].Last = inclusiveEnd
+	log.Printf("[INFO] Scanning %s - %s in %d shard(s) (-shard %s) with up to %d in parallel",
+		from.Format(time.RFC3339), to.Format(time.RFC3339), len(shards), shardMode, parallelism)
+	days, err := scanShards(ctx, clt, shards, eventTypes, parallelism)
+	if err != nil {
+		return nil, err
+	}
+	logUnrecognized(days)
+	return days, nil
+}
+
+// logUnrecognized warns about scanned events that were skipped: ones the
+// API could not decode, ones the mapping does not count and ones without a
+// user.
+func logUnrecognized(days dailyAccums) {
+	unrecognized := make(map[string]int)
+	for _, a := range days {
+		for eventType, n := range a.unrecognized {
+			unrecognized[eventType] += n
+		}
+	}
+	for _, eventType := range sortedKeys(unrecognized) {
+		log.Printf("[WARN] Skipped %d %q event(s) that could not be decoded, are not counted by the mapping or have no user",
+			unrecognized[eventType], eventType)
+	}
+}
+
+// maxEventSize bounds a single line of an exported audit log. Teleport caps
+// events well below this, so a longer line means the file is not JSON lines.
+const maxEventSize = 16 * 1024 * 1024
+
+// isInputFile reports whether a file found while walking an -input directory
+// looks like exported audit events: JSON lines, optionally gzip-compressed.
+func isInputFile(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".gz")
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".log")
+}
+
+// inputFiles expands the -input paths into a sorted list of files. Files
+// named directly are always read; directories, such as a local copy of an
+// S3 audit bucket, are walked recursively for isInputFile matches.
+func inputFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isInputFile(d.Name()) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// userKindNames maps the string forms of apievents.UserKind that exporters
+// may write instead of the enum number.
+var userKindNames = map[string]apievents.UserKind{
+	"USER_KIND_UNSPECIFIED": apievents.UserKind_USER_KIND_UNSPECIFIED,
+	"USER_KIND_HUMAN":       apievents.UserKind_USER_KIND_HUMAN,
+	"USER_KIND_BOT":         apievents.UserKind_USER_KIND_BOT,
+	"HUMAN":                 apievents.UserKind_USER_KIND_HUMAN,
+	"BOT":                   apievents.UserKind_USER_KIND_BOT,
+}
+
+// decodeEvent decodes one exported audit event. It returns a nil event with
+// no error for types that are not tracked.
+func decodeEvent(line []byte, tracked map[string]bool) (*eventFields, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return nil, err
+	}
+	var eventType string
+	if err := json.Unmarshal(fields["event"], &eventType); err != nil {
+		return nil, fmt.Errorf("missing event type")
+	}
+	if !tracked[eventType] {
+		return nil, nil
+	}
+
+	// Some exporters write user_kind as the enum name rather than its number.
+	var kindName string
+	if json.Unmarshal(fields["user_kind"], &kindName) == nil {
+		kind := userKindNames[strings.ToUpper(kindName)]
+		fields["user_kind"] = json.RawMessage(fmt.Sprint(int32(kind)))
+		rewritten, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		line = rewritten
+	}
+
+	event := &eventFields{}
+	if err := json.Unmarshal(line, event); err != nil {
+		return nil, fmt.Errorf("%s: %w", eventType, err)
+	}
+	return event, nil
+}
+
+// readInputFile ingests the tracked events of one exported audit log into
+// per-day accumulators, and returns the time of the newest event in it.
+// Lines that cannot be decoded are skipped with a warning.
+func readInputFile(path string, tracked map[string]bool) (dailyAccums, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	days := dailyAccums{}
+	var (
+		latest    time.Time
+		invalid   int
+		firstErr  error
+		firstLine int
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		event, err := decodeEvent(line, tracked)
+		if err != nil {
+			if invalid == 0 {
+				firstErr, firstLine = err, lineNo
+			}
+			invalid++
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		et := event.GetTime().UTC()
+		if et.After(latest) {
+			latest = et
+		}
+		days.day(et).ingestFields(event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+	if invalid > 0 {
+		log.Printf("[WARN] %s: skipped %d line(s) that could not be decoded (first at line %d: %v)",
+			path, invalid, firstLine, firstErr)
+	}
+	return days, latest, nil
+}
+
+// readInputs reads exported audit events from files instead of SearchEvents,
+// with up to parallelism files in flight. Every event is kept; the caller
+// trims the days to its report window once it knows where that ends.
+func readInputs(paths []string, eventTypes []string) (dailyAccums, time.Time, error) {
+	files, err := inputFiles(paths)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(files) == 0 {
+		return nil, time.Time{}, fmt.Errorf("no audit event files found in %s", strings.Join(paths, ", "))
+	}
+	log.Printf("[INFO] Reading audit events from %d file(s) with up to %d in parallel", len(files), parallelism)
+
+	tracked := make(map[string]bool, len(eventTypes))
+	for _, t := range eventTypes {
+		tracked[t] = true
+	}
+
+	results := make([]dailyAccums, len(files))
+	latests := make([]time.Time, len(files))
+	errs := make([]error, len(files))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for i, file := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], latests[i], errs[i] = readInputFile(file, tracked)
+		}()
+	}
+	wg.Wait()
+
+	merged := dailyAccums{}
+	var latest time.Time
+	for i, file := range files {
+		if errs[i] != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %w", file, errs[i])
+		}
+		merged.merge(results[i])
+		if latests[i].After(latest) {
+			latest = latests[i]
+		}
+	}
+	logUnrecognized(merged)
+	return merged, latest, nil
+}
+
+// trim drops the days outside [from, to].
+func (d dailyAccums) trim(from, to time.Time) {
+	for day := range d {
+		if day.Before(dayStart(from)) || day.After(to) {
+			delete(d, day)
+		}
+	}
+}
+
+// storeDayFormat is how days are keyed in the checkpoint store.
+const storeDayFormat = "2006-01-02"
+
+// storeTimeFormat is a fixed-width UTC timestamp, so SQLite's MIN/MAX on the
+// text columns order it correctly.
+const storeTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// mauStore keeps the per-user, per-day aggregates built by cycleAccum.ingest,
+// together with the span of the audit log they cover, so later runs only
+// fetch events they have not seen yet.
+type mauStore struct {
+	db *sql.DB
+}
+
+// openStore opens (or creates) the SQLite checkpoint store at path.
+func openStore(path string) (*mauStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_user_day (
+		day TEXT NOT NULL,
+		username TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		login_count INTEGER NOT NULL DEFAULT 0,
+		ssh INTEGER NOT NULL DEFAULT 0,
+		kubernetes INTEGER NOT NULL DEFAULT 0,
+		database INTEGER NOT NULL DEFAULT 0,
+		application INTEGER NOT NULL DEFAULT 0,
+		desktop INTEGER NOT NULL DEFAULT 0,
+		access_requests_created INTEGER NOT NULL DEFAULT 0,
+		access_requests_reviewed INTEGER NOT NULL DEFAULT 0,
+		access_lists_memberships INTEGER NOT NULL DEFAULT 0,
+		access_lists_reviewed INTEGER NOT NULL DEFAULT 0,
+		saml_idp_sessions INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, username)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_user_day table: %w", err)
+	}
+
+	// Per-resource detail, only written for days scanned with -detail.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_resource_day (
+		day TEXT NOT NULL,
+		username TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		name TEXT NOT NULL,
+		account TEXT NOT NULL,
+		first_seen TEXT NOT NULL,
+		last_seen TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, username, kind, name, account)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_resource_day table: %w", err)
+	}
+
+	// Security signals, only written for days scanned with -security.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_signal_day (
+		day TEXT NOT NULL,
+		username TEXT NOT NULL,
+		signal TEXT NOT NULL,
+		value TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, username, signal, value)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_signal_day table: %w", err)
+	}
+
+	// Session starts and ends, only written for days scanned with -sessions.
+	// A session is stored under each day it has an event on; started and
+	// ended are empty until seen.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_session_day (
+		day TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		category TEXT NOT NULL,
+		started TEXT NOT NULL,
+		ended TEXT NOT NULL,
+		PRIMARY KEY (day, session_id)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_session_day table: %w", err)
+	}
+
+	// The optional collectors (-detail, -security, -sessions) each stored day
+	// was scanned with. A day is only listed under a collector if every scan
+	// of it had it on.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_collector_day (
+		day TEXT NOT NULL,
+		collector TEXT NOT NULL,
+		PRIMARY KEY (day, collector)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_collector_day table: %w", err)
+	}
+
+	// A single row recording which part of the audit log has been scanned:
+	// everything in [covered_from, scanned_through) is in mau_user_day.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_checkpoint (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		covered_from TEXT NOT NULL,
+		scanned_through TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_checkpoint table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS mau_setting (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create mau_setting table: %w", err)
+	}
+
+	store := &mauStore{db: db}
+	if err := store.checkTimezone(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := store.checkMapping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// checkTimezone pins the store to the -tz it was created with. Days are
+// stored by local date, so reading them in another timezone would shift
+// every day boundary. Stores from before -tz existed hold UTC days.
+func (s *mauStore) checkTimezone() error {
+	var tz string
+	err := s.db.QueryRow(`SELECT value FROM mau_setting WHERE name = 'timezone'`).Scan(&tz)
+	if err == sql.ErrNoRows {
+		_, _, used, err := s.checkpoint()
+		if err != nil {
+			return err
+		}
+		tz = reportLocation.String()
+		if used {
+			tz = "UTC"
+		}
+		if _, err := s.db.Exec(`INSERT INTO mau_setting (name, value) VALUES ('timezone', ?)`, tz); err != nil {
+			return fmt.Errorf("failed to record store timezone: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read store timezone: %w", err)
+	}
+	if tz != reportLocation.String() {
+		return fmt.Errorf("store days are in timezone %s; run with -tz %s or use a new -store file", tz, tz)
+	}
+	return nil
+}
+
+// checkMapping pins the store to the event mapping it was filled with. The
+// stored columns are counted by the mapping, so mixing days counted under
+// two mappings would add up figures that mean different things. Stores from
+// before the mapping was recorded are assumed to match.
+func (s *mauStore) checkMapping() error {
+	data, err := json.Marshal(mapping.rules())
+	if err != nil {
+		return fmt.Errorf("failed to encode event mapping: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	var stored string
+	err = s.db.QueryRow(`SELECT value FROM mau_setting WHERE name = 'mapping'`).Scan(&stored)
+	if err == sql.ErrNoRows {
+		if _, _, used, err := s.checkpoint(); err != nil {
+			return err
+		} else if used {
+			log.Printf("[WARN] Store does not record the event mapping it was filled with; assuming the current one")
+		}
+		if _, err := s.db.Exec(`INSERT INTO mau_setting (name, value) VALUES ('mapping', ?)`, fingerprint); err != nil {
+			return fmt.Errorf("failed to record store mapping: %w", err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read store mapping: %w", err)
+	}
+	if stored != fingerprint {
+		return fmt.Errorf("store was filled with a different event mapping; run with the -mapping it was filled with or use a new -store file")
+	}
+	return nil
+}
+
+func (s *mauStore) Close() error {
+	return s.db.Close()
+}
+
+// checkpoint returns the span covered by the store. ok is false for a new store.
+func (s *mauStore) checkpoint() (coveredFrom, scannedThrough time.Time, ok bool, err error) {
+	var from, through string
+	err = s.db.QueryRow(`SELECT covered_from, scanned_through FROM mau_checkpoint WHERE id = 1`).Scan(&from, &through)
+	if err == sql.ErrNoRows {
+		return time.Time{}, time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if coveredFrom, err = time.Parse(time.RFC3339Nano, from); err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("invalid covered_from %q: %w", from, err)
+	}
+	if scannedThrough, err = time.Parse(time.RFC3339Nano, through); err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("invalid scanned_through %q: %w", through, err)
+	}
+	return coveredFrom, scannedThrough, true, nil
+}
+
+// save adds freshly scanned days to the stored aggregates and moves the
+// checkpoint in the same transaction, so an interrupted run never leaves
+// events counted without the checkpoint knowing about them (or vice versa).
+//
+// scanned lists every day the scans touched. A true entry means the day was
+// scanned whole in this run: whatever the store held for it is replaced and
+// it is recorded with this run's collectors. A false entry adds to the
+// stored day, which then keeps only the collectors both scans had on.
+func (s *mauStore) save(days dailyAccums, coveredFrom, scannedThrough time.Time, scanned map[time.Time]bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	on := collectors()
+	for day, whole := range scanned {
+		key := day.Format(storeDayFormat)
+		if !whole {
+			query, args := `DELETE FROM mau_collector_day WHERE day = ?`, []interface{}{key}
+			if len(on) > 0 {
+				query += ` AND collector NOT IN (?` + strings.Repeat(`, ?`, len(on)-1) + `)`
+				for _, c := range on {
+					args = append(args, c)
+				}
+			}
+			if _, err := tx.Exec(query, args...); err != nil {
+				return fmt.Errorf("failed to record collectors of %s: %w", key, err)
+			}
+			continue
+		}
+		for _, table := range []string{"mau_user_day", "mau_resource_day", "mau_signal_day", "mau_session_day", "mau_collector_day"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE day = ?`, key); err != nil {
+				return fmt.Errorf("failed to clear %s from %s: %w", key, table, err)
+			}
+		}
+		for _, c := range on {
+			if _, err := tx.Exec(`INSERT INTO mau_collector_day (day, collector) VALUES (?, ?)`, key, c); err != nil {
+				return fmt.Errorf("failed to record collectors of %s: %w", key, err)
+			}
+		}
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO mau_user_day (
+		day, username, kind, login_count, ssh, kubernetes, database, application, desktop,
+		access_requests_created, access_requests_reviewed, access_lists_memberships,
+		access_lists_reviewed, saml_idp_sessions
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (day, username) DO UPDATE SET
+		kind = CASE WHEN excluded.kind = 'Bot' OR kind = '' THEN excluded.kind ELSE kind END,
+		login_count = login_count + excluded.login_count,
+		ssh = ssh + excluded.ssh,
+		kubernetes = kubernetes + excluded.kubernetes,
+		database = database + excluded.database,
+		application = application + excluded.application,
+		desktop = desktop + excluded.desktop,
+		access_requests_created = access_requests_created + excluded.access_requests_created,
+		access_requests_reviewed = access_requests_reviewed + excluded.access_requests_reviewed,
+		access_lists_memberships = access_lists_memberships + excluded.access_lists_memberships,
+		access_lists_reviewed = access_lists_reviewed + excluded.access_lists_reviewed,
+		saml_idp_sessions = saml_idp_sessions + excluded.saml_idp_sessions
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, day := range days.sortedDays() {
+		a := days[day]
+		for _, user := range sortedKeys(a.userKind) {
+			zta := a.userResourceUsage[user]
+			if zta == nil {
+				zta = &UserResourceUsage{}
+			}
+			ig := a.userIGUsage[user]
+			if ig == nil {
+				ig = &UserIGUsage{}
+			}
+			_, err := stmt.Exec(
+				day.Format(storeDayFormat), user, string(a.userKind[user]),
+				zta.LoginCount, zta.SSH, zta.Kubernetes, zta.Database, zta.Application, zta.Desktop,
+				ig.AccessRequestsCreated, ig.AccessRequestsReviewed, ig.AccessListsMemberships,
+				ig.AccessListsReviewed, ig.SAMLIDPSessions,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to store %s/%s: %w", day.Format(storeDayFormat), user, err)
+			}
+		}
+	}
+
+	resStmt, err := tx.Prepare(`
+	INSERT INTO mau_resource_day (day, username, kind, name, account, first_seen, last_seen, count)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (day, username, kind, name, account) DO UPDATE SET
+		first_seen = MIN(first_seen, excluded.first_seen),
+		last_seen = MAX(last_seen, excluded.last_seen),
+		count = count + excluded.count
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare resource upsert: %w", err)
+	}
+	defer resStmt.Close()
+
+	for _, day := range days.sortedDays() {
+		resources := days[day].resources
+		for _, user := range sortedKeys(resources) {
+			for _, r := range resources.sorted(user) {
+				_, err := resStmt.Exec(
+					day.Format(storeDayFormat), user, r.Kind, r.Name, r.Account,
+					r.FirstSeen.UTC().Format(storeTimeFormat), r.LastSeen.UTC().Format(storeTimeFormat), r.Count,
+				)
+				if err != nil {
+					return fmt.Errorf("failed to store %s/%s resource %s: %w", day.Format(storeDayFormat), user, r.Name, err)
+				}
+			}
+		}
+	}
+
+	sigStmt, err := tx.Prepare(`
+	INSERT INTO mau_signal_day (day, username, signal, value, count)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (day, username, signal, value) DO UPDATE SET
+		count = count + excluded.count
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare signal upsert: %w", err)
+	}
+	defer sigStmt.Close()
+
+	for _, day := range days.sortedDays() {
+		signals := days[day].signals
+		for _, user := range sortedKeys(signals) {
+			for _, signal := range sortedKeys(signals[user]) {
+				for value, n := range signals[user][signal] {
+					if _, err := sigStmt.Exec(day.Format(storeDayFormat), user, signal, value, n); err != nil {
+						return fmt.Errorf("failed to store %s/%s signal %s: %w", day.Format(storeDayFormat), user, signal, err)
+					}
+				}
+			}
+		}
+	}
+
+	sessStmt, err := tx.Prepare(`
+	INSERT INTO mau_session_day (day, session_id, username, category, started, ended)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (day, session_id) DO UPDATE SET
+		started = CASE WHEN started = '' OR (excluded.started != '' AND excluded.started < started) THEN excluded.started ELSE started END,
+		ended = MAX(ended, excluded.ended)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare session upsert: %w", err)
+	}
+	defer sessStmt.Close()
+
+	for _, day := range days.sortedDays() {
+		sessions := days[day].sessions
+		for _, id := range sortedKeys(sessions) {
+			span := sessions[id]
+			_, err := sessStmt.Exec(
+				day.Format(storeDayFormat), id, span.User, span.Category,
+				storeTime(span.Start), storeTime(span.End),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to store %s session %s: %w", day.Format(storeDayFormat), id, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO mau_checkpoint (id, covered_from, scanned_through) VALUES (1, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET covered_from = excluded.covered_from, scanned_through = excluded.scanned_through
+	`, coveredFrom.UTC().Format(time.RFC3339Nano), scannedThrough.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("failed to update checkpoint: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// load rebuilds the per-day accumulators for every stored day that starts
+// inside [from, to).
+func (s *mauStore) load(from, to time.Time) (dailyAccums, error) {
+	end := dayStart(to)
+	if end.Before(to) {
+		end = end.AddDate(0, 0, 1)
+	}
+	rows, err := s.db.Query(`
+	SELECT day, username, kind, login_count, ssh, kubernetes, database, application, desktop,
+		access_requests_created, access_requests_reviewed, access_lists_memberships,
+		access_lists_reviewed, saml_idp_sessions
+	FROM mau_user_day
+	WHERE day >= ? AND day < ?
+	`, dayStart(from).Format(storeDayFormat), end.Format(storeDayFormat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored days: %w", err)
+	}
+	defer rows.Close()
+
+	days := dailyAccums{}
+	for rows.Next() {
+		var (
+			user, kind string
+			zta        UserResourceUsage
+			ig         UserIGUsage
+		)
+		day, err := scanStoredDay(rows, &user, &kind,
+			&zta.LoginCount, &zta.SSH, &zta.Kubernetes, &zta.Database, &zta.Application, &zta.Desktop,
+			&ig.AccessRequestsCreated, &ig.AccessRequestsReviewed, &ig.AccessListsMemberships,
+			&ig.AccessListsReviewed, &ig.SAMLIDPSessions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored day: %w", err)
+		}
+
+		a := days.day(day)
+		a.userKind[user] = UserKindLabel(kind)
+		a.userResourceUsage[user] = &zta
+		a.userIGUsage[user] = &ig
+		a.totalLogins += zta.LoginCount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if securityMode {
+		if err := s.loadSignals(days, from, end); err != nil {
+			return nil, err
+		}
+	}
+	if sessionMode {
+		if err := s.loadSessions(days, from, end); err != nil {
+			return nil, err
+		}
+	}
+	if !detailMode {
+		return days, nil
+	}
+
+	resRows, err := s.db.Query(`
+	SELECT day, username, kind, name, account, first_seen, last_seen, count
+	FROM mau_resource_day
+	WHERE day >= ? AND day < ?
+	`, dayStart(from).Format(storeDayFormat), end.Format(storeDayFormat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored resources: %w", err)
+	}
+	defer resRows.Close()
+
+	for resRows.Next() {
+		var (
+			user, first, last string
+			r                 resourceSeen
+		)
+		day, err := scanStoredDay(resRows, &user, &r.Kind, &r.Name, &r.Account, &first, &last, &r.Count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored resource: %w", err)
+		}
+		if r.FirstSeen, err = time.Parse(storeTimeFormat, first); err != nil {
+			return nil, fmt.Errorf("invalid stored first_seen %q: %w", first, err)
+		}
+		if r.LastSeen, err = time.Parse(storeTimeFormat, last); err != nil {
+			return nil, fmt.Errorf("invalid stored last_seen %q: %w", last, err)
+		}
+		days.day(day).resources.add(user, r)
+	}
+	return days, resRows.Err()
+}
+
+// loadSignals adds the stored -security signals of the days in [from, end)
+// to days.
+func (s *mauStore) loadSignals(days dailyAccums, from, end time.Time) error {
+	rows, err := s.db.Query(`
+	SELECT day, username, signal, value, count
+	FROM mau_signal_day
+	WHERE day >= ? AND day < ?
+	`, dayStart(from).Format(storeDayFormat), end.Format(storeDayFormat))
+	if err != nil {
+		return fmt.Errorf("failed to query stored signals: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			user, signal, value string
+			n                   int
+		)
+		day, err := scanStoredDay(rows, &user, &signal, &value, &n)
+		if err != nil {
+			return fmt.Errorf("failed to read stored signal: %w", err)
+		}
+		days.day(day).signals.add(user, signal, value, n)
+	}
+	return rows.Err()
+}
+
+// loadSessions adds the stored -sessions starts and ends of the days in
+// [from, end) to days.
+func (s *mauStore) loadSessions(days dailyAccums, from, end time.Time) error {
+	rows, err := s.db.Query(`
+	SELECT day, session_id, username, category, started, ended
+	FROM mau_session_day
+	WHERE day >= ? AND day < ?
+	`, dayStart(from).Format(storeDayFormat), end.Format(storeDayFormat))
+	if err != nil {
+		return fmt.Errorf("failed to query stored sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id, start, stop string
+			span            sessionSpan
+		)
+		day, err := scanStoredDay(rows, &id, &span.User, &span.Category, &start, &stop)
+		if err != nil {
+			return fmt.Errorf("failed to read stored session: %w", err)
+		}
+		if span.Start, err = parseStoreTime(start); err != nil {
+			return fmt.Errorf("invalid stored session start %q: %w", start, err)
+		}
+		if span.End, err = parseStoreTime(stop); err != nil {
+			return fmt.Errorf("invalid stored session end %q: %w", stop, err)
+		}
+		days.day(day).sessions.add(id, span)
+	}
+	return rows.Err()
+}
+
+// dayCollectors returns the collectors recorded for each stored day in
+// [from, end).
+func (s *mauStore) dayCollectors(from, end time.Time) (map[time.Time]map[string]bool, error) {
+	rows, err := s.db.Query(`
+	SELECT day, collector
+	FROM mau_collector_day
+	WHERE day >= ? AND day < ?
+	`, dayStart(from).Format(storeDayFormat), end.Format(storeDayFormat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored collectors: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[time.Time]map[string]bool)
+	for rows.Next() {
+		var collector string
+		day, err := scanStoredDay(rows, &collector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored collector: %w", err)
+		}
+		if out[day] == nil {
+			out[day] = make(map[string]bool)
+		}
+		out[day][collector] = true
+	}
+	return out, rows.Err()
+}
+
+// activeDays returns the stored days in [from, end) with any user activity.
+func (s *mauStore) activeDays(from, end time.Time) (map[time.Time]bool, error) {
+	rows, err := s.db.Query(`
+	SELECT DISTINCT day
+	FROM mau_user_day
+	WHERE day >= ? AND day < ?
+	`, dayStart(from).Format(storeDayFormat), end.Format(storeDayFormat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored days: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[time.Time]bool)
+	for rows.Next() {
+		day, err := scanStoredDay(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored day: %w", err)
+		}
+		out[day] = true
+	}
+	return out, rows.Err()
+}
+
+// collectors lists the optional collectors this run has on, by the name the
+// store records them under.
+func collectors() []string {
+	var out []string
+	if detailMode {
+		out = append(out, "detail")
+	}
+	if securityMode {
+		out = append(out, "security")
+	}
+	if sessionMode {
+		out = append(out, "sessions")
+	}
+	return out
+}
+
+// scanStoredDay scans a row whose first column is a stored day into the
+// day, at midnight in -tz, and the remaining columns into dest.
+func scanStoredDay(rows *sql.Rows, dest ...interface{}) (time.Time, error) {
+	var dayStr string
+	if err := rows.Scan(append([]interface{}{&dayStr}, dest...)...); err != nil {
+		return time.Time{}, err
+	}
+	day, err := time.ParseInLocation(storeDayFormat, dayStr, reportLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid stored day %q: %w", dayStr, err)
+	}
+	return day, nil
+}
+
+// storeTime formats t for a nullable time column of the store: the zero
+// time is stored as an empty string.
+func storeTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(storeTimeFormat)
+}
+
+// parseStoreTime is the inverse of storeTime.
+func parseStoreTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(storeTimeFormat, s)
+}
+
+// syncStore scans only the parts of [from, to) the store has not seen yet
+// with scan, saves them, and returns the stored days for the whole window.
+// The window is widened to whole days so every stored day is complete.
+// Stored days of the window that lack one of this run's collectors are
+// scanned again and replaced, so -detail, -security and -sessions never read
+// an empty day as no activity.
+func syncStore(store *mauStore, from, to time.Time, scan func(from, to time.Time) (dailyAccums, error)) (dailyAccums, error) {
+	from = dayStart(from)
+
+	coveredFrom, scannedThrough, ok, err := store.checkpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	type span struct{ from, to time.Time }
+	var missing []span
+	rescan := make(map[time.Time]bool)
+	if !ok {
+		missing = append(missing, span{from, to})
+		coveredFrom, scannedThrough = from, to
+	} else {
+		log.Printf("[INFO] Store covers %s - %s",
+			coveredFrom.Format(time.RFC3339), scannedThrough.Format(time.RFC3339))
+
+		if on := collectors(); len(on) > 0 {
+			recorded, err := store.dayCollectors(coveredFrom, scannedThrough.AddDate(0, 0, 1))
+			if err != nil {
+				return nil, err
+			}
+			day := dayStart(coveredFrom)
+			if from.After(day) {
+				day = from
+			}
+			lacking := make(map[string]int)
+			for ; day.Before(to) && day.Before(scannedThrough); day = day.AddDate(0, 0, 1) {
+				for _, c := range on {
+					if !recorded[day][c] {
+						rescan[day] = true
+						lacking[c]++
+					}
+				}
+				if !rescan[day] {
+					continue
+				}
+				end := day.AddDate(0, 0, 1)
+				if end.After(scannedThrough) {
+					end = scannedThrough
+				}
+				if n := len(missing); n > 0 && missing[n-1].to.Equal(day) {
+					missing[n-1].to = end
+				} else {
+					missing = append(missing, span{day, end})
+				}
+			}
+			for _, c := range sortedKeys(lacking) {
+				log.Printf("[INFO] Rescanning %d stored day(s) that were scanned without -%s", lacking[c], c)
+			}
+		}
+
+		if from.Before(coveredFrom) {
+			missing = append(missing, span{from, coveredFrom})
+		}
+		if to.After(scannedThrough) {
+			missing = append(missing, span{scannedThrough, to})
+		}
+	}
+
+	// A day is scanned whole unless part of it was already stored and is
+	// kept: the day the previous checkpoint ends in, when not rescanned.
+	scanned := make(map[time.Time]bool)
+	for _, m := range missing {
+		for day := dayStart(m.from); day.Before(m.to); day = day.AddDate(0, 0, 1) {
+			kept := ok && !rescan[day] && !day.Before(coveredFrom) && day.Before(scannedThrough)
+			scanned[day] = !kept
+		}
+	}
+
+	fresh := dailyAccums{}
+	for _, m := range missing {
+		days, err := scan(m.from, m.to)
+		if err != nil {
+			return nil, err
+		}
+		fresh.merge(days)
+	}
+
+	// A rescan that finds nothing for a day with stored activity is most
+	// likely past the audit log's retention: keep what the store holds.
+	if len(rescan) > 0 {
+		active, err := store.activeDays(coveredFrom, scannedThrough.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		expired := 0
+		for day := range rescan {
+			if fresh[day] == nil && active[day] {
+				scanned[day] = false
+				expired++
+			}
+		}
+		if expired > 0 {
+			log.Printf("[WARN] %d stored day(s) returned no events when rescanned, likely past the audit log's retention; they are reported without %s",
+				expired, "-"+strings.Join(collectors(), ", -"))
+		}
+	}
+
+	if from.Before(coveredFrom) {
+		coveredFrom = from
+	}
+	if to.After(scannedThrough) {
+		scannedThrough = to
+	}
+	if err := store.save(fresh, coveredFrom, scannedThrough, scanned); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Stored %d day(s) of new activity; store now covers %s - %s",
+		len(fresh), coveredFrom.Format(time.RFC3339), scannedThrough.Format(time.RFC3339))
+
+	return store.load(from, to)
+}
+
+// clusterTarget is one cluster named in -proxy, with the identity file used
+// to reach it (empty for the active tsh profile).
+type clusterTarget struct {
+	Proxy        string
+	IdentityFile string
+}
+
+// clusterRun is the activity read from one cluster.
+type clusterRun struct {
+	Name  string // proxy host, used to label the cluster in the report
+	Days  dailyAccums
+	Users []types.User // nil if the user list could not be read
+
+	classes map[string]userClassification // set by classify
+	keys    map[string]string             // username -> identity key, set by classify
+}
+
+// clusterName labels a cluster by its proxy host.
+func clusterName(proxy string) string {
+	if host, _, err := net.SplitHostPort(proxy); err == nil {
+		return host
+	}
+	return proxy
+}
+
+// clusterStorePath gives each cluster its own -store file when several are
+// scanned: teleport_mau_store.db becomes teleport_mau_store.<host>.db.
+func clusterStorePath(path, cluster string, multi bool) string {
+	if path == "" || !multi {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + cluster + ext
+}
+
+// scanCluster connects to one cluster, fetches the window's events (through
+// storeFile, if set) and lists the cluster's users.
+func scanCluster(ctx context.Context, target clusterTarget, storeFile string, from, to time.Time, cycles []cycleBounds, eventTypes []string) (clusterRun, error) {
+	run := clusterRun{Name: clusterName(target.Proxy)}
+
+	// Build credentials based on configuration
+	var credentials []client.Credentials
+	if target.IdentityFile != "" {
+		credentials = []client.Credentials{
+			client.LoadIdentityFile(target.IdentityFile),
+		}
+	} else {
+		credentials = []client.Credentials{
+			client.LoadProfile("", ""),
+		}
+	}
+
+	clt, err := client.New(ctx, client.Config{
+		Addrs:       []string{target.Proxy},
+		Credentials: credentials,
+	})
+	if err != nil {
+		return run, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer clt.Close()
+
+	if storeFile != "" {
+		store, err := openStore(storeFile)
+		if err != nil {
+			return run, fmt.Errorf("failed to open store %s: %w", storeFile, err)
+		}
+		defer store.Close()
+		run.Days, err = syncStore(store, from, to, func(from, to time.Time) (dailyAccums, error) {
+			return scanWindow(ctx, clt, from, to, cycles, eventTypes, false)
+		})
+		if err != nil {
+			return run, fmt.Errorf("failed to update store %s: %w", storeFile, err)
+		}
+	} else {
+		run.Days, err = scanWindow(ctx, clt, from, to, cycles, eventTypes, true)
+		if err != nil {
+			return run, fmt.Errorf("failed to fetch events: %w", err)
+		}
+	}
+
+	// User resources drive both bot classification and -group-by. Without
+	// them classification falls back to event fields and heuristics.
+	run.Users, err = clt.GetUsers(ctx, false)
+	if err != nil {
+		if groupBy != "" {
+			return run, fmt.Errorf("failed to list users for -group-by %s: %w", groupBy, err)
+		}
+		if dormantDays > 0 {
+			return run, fmt.Errorf("failed to list users for -dormant-days: %w", err)
+		}
+		log.Printf("[WARN] %s: failed to list users, classifying humans and bots from audit events only: %v", run.Name, err)
+	}
+	return run, nil
+}
+
+// scanClusters scans every cluster concurrently and returns the runs in
+// -proxy order. Each cluster still splits its window into -parallel shards.
+func scanClusters(ctx context.Context, targets []clusterTarget, from, to time.Time, cycles []cycleBounds, eventTypes []string) ([]clusterRun, error) {
+	runs := make([]clusterRun, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storeFile := clusterStorePath(storePath, clusterName(target.Proxy), len(targets) > 1)
+			runs[i], errs[i] = scanCluster(ctx, target, storeFile, from, to, cycles, eventTypes)
+		}()
+	}
+	wg.Wait()
+
+	for i, target := range targets {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s: %w", target.Proxy, errs[i])
+		}
+	}
+	return runs, nil
+}
+
+// identityKey is what a cluster user is deduplicated by across clusters
+// (-dedupe-by): the username, the email trait or the SSO subject. It falls
+// back to the username when the user has no email trait or SSO identity.
+func identityKey(u types.User, by string) string {
+	switch by {
+	case "email":
+		for _, email := range u.GetTraits()["email"] {
+			if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+				return email
+			}
+		}
+	case "sso":
+		if c := u.GetCreatedBy().Connector; c != nil && c.Identity != "" {
+			return c.Identity
+		}
+	}
+	return u.GetName()
+}
+
+// classify classifies the cluster's users on their own, exactly as a
+// single-cluster report would, and works out each user's identity key.
+func (r *clusterRun) classify() {
+	r.classes = classifyUsers(r.Users, r.Days.accums())
+	r.keys = make(map[string]string, len(r.Users))
+	for _, u := range r.Users {
+		if u.IsBot() {
+			r.keys[u.GetName()] = u.GetName() + "@" + r.Name
+		} else {
+			r.keys[u.GetName()] = identityKey(u, dedupeBy)
+		}
+	}
+}
+
+// identity returns the key a user of this cluster is counted under in the
+// combined report. Bots belong to one cluster, so they are never merged.
+func (r *clusterRun) identity(user string) string {
+	if r.classes[user].Kind == UserKindBot {
+		return user + "@" + r.Name
+	}
+	if key, ok := r.keys[user]; ok {
+		return key
+	}
+	return user
+}
+
+// outranks reports whether classification a should replace b when two
+// accounts share an identity: bot evidence wins, as in strongerKind, then
+// evidence beats a heuristic.
+func outranks(a, b userClassification) bool {
+	if a.Kind != b.Kind {
+		return a.Kind == UserKindBot
+	}
+	return b.Heuristic && !a.Heuristic
+}
+
+// combineClusters merges the classified runs into one set of days keyed by
+// identity, so that a person active in several clusters is counted once. It
+// returns the merged days and the classification of each identity.
+func combineClusters(runs []clusterRun) (dailyAccums, map[string]userClassification) {
+	days := dailyAccums{}
+	classes := make(map[string]userClassification)
+	for i := range runs {
+		r := &runs[i]
+		for _, day := range r.Days.sortedDays() {
+			days.day(day).mergeAs(r.Days[day], r.identity)
+		}
+		for user, c := range r.classes {
+			c.User = r.identity(user)
+			if prev, ok := classes[c.User]; ok && !outranks(c, prev) {
+				continue
+			}
+			classes[c.User] = c
+		}
+	}
+	return days, classes
+}
+
+// combineGroups is lookupUserGroups across clusters: an identity belongs to
+// every group any of its accounts belongs to.
+func combineGroups(runs []clusterRun) map[string][]string {
+	out := make(map[string][]string)
+	for i := range runs {
+		r := &runs[i]
+		for user, groups := range lookupUserGroups(r.Users, groupBy) {
+			key := r.identity(user)
+			out[key] = mergeSorted(out[key], groups)
+		}
+	}
+	return out
+}
+
+// findDormantIdentities is findDormantUsers across clusters. An identity is
+// dormant only if it was not active in any cluster; its row lists the roles
+// of all its accounts and the earliest creation date.
+func findDormantIdentities(runs []clusterRun, days dailyAccums, end time.Time) []dormantUser {
+	lastSeen := lastActiveDays(days)
+	cutoff := dormantCutoff(end)
+	rows := make(map[string]*dormantUser)
+	for i := range runs {
+		r := &runs[i]
+		for _, u := range r.Users {
+			key := r.identity(u.GetName())
+			seen, ok := lastSeen[key]
+			if ok && !seen.Before(cutoff) {
+				continue
+			}
+			d := newDormantUser(u, seen, ok)
+			d.User = key
+			row := rows[key]
+			if row == nil {
+				rows[key] = &d
+				continue
+			}
+			row.Kind = strongerKind(row.Kind, d.Kind)
+			row.Roles = mergeSorted(row.Roles, d.Roles)
+			if d.Created != "" && (row.Created == "" || d.Created < row.Created) {
+				row.Created = d.Created
+			}
+		}
+	}
+
+	out := make([]dormantUser, 0, len(rows))
+	for _, key := range sortedKeys(rows) {
+		out = append(out, *rows[key])
+	}
+	return out
+}
+
+// mergeSorted returns the sorted union of two string lists.
+func mergeSorted(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	out := []string{}
+	for _, v := range append(append([]string{}, a...), b...) {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// combinedCluster labels the deduplicated total in the per-cluster table.
+const combinedCluster = "(all, deduplicated)"
+
+// clusterRow is one cluster's MAU for one cycle.
+type clusterRow struct {
+	Cluster string `json:"cluster"`
+	Cycle   string `json:"cycle"`
+	ZTA     int    `json:"ztamau_users"`
+	IG      int    `json:"igmau_users"`
+	MWI     int    `json:"mwi_bots"`
+	Logins  int    `json:"successful_logins"`
+}
+
+// buildClusterTotals returns each cluster's MAU per cycle, counted on its
+// own, followed by the deduplicated total from the combined accumulators.
+func buildClusterTotals(runs []clusterRun, cycles []cycleBounds, combined []*cycleAccum) []clusterRow {
+	var rows []clusterRow
+	for i, c := range cycles {
+		for _, r := range runs {
+			a := r.Days.fold(c.Start, c.End)
+			counts := a.summarize().counts()
+			rows = append(rows, clusterRow{r.Name, cycleLabel(c), counts.ZTA, counts.IG, counts.MWI, a.totalLogins})
+		}
+		counts := combined[i].summarize().counts()
+		rows = append(rows, clusterRow{combinedCluster, cycleLabel(c), counts.ZTA, counts.IG, counts.MWI, combined[i].totalLogins})
+	}
+	return rows
+}
+
+// formatClusterTable renders the per-cluster section of a multi-cluster
+// report.
+func (rc *reportContext) formatClusterTable() string {
+	clusterWidth, cycleWidth := len("Cluster"), len("Cycle")
+	for _, row := range rc.clusterTotals {
+		if len(row.Cluster) > clusterWidth {
+			clusterWidth = len(row.Cluster)
+		}
+		if len(row.Cycle) > cycleWidth {
+			cycleWidth = len(row.Cycle)
+		}
+	}
+	clusterWidth += 2
+	cycleWidth += 2
+
+	output := fmt.Sprintf("MAU BY CLUSTER (identities deduplicated by %s)\n", dedupeBy)
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-*s  %-8s  %-8s  %-6s  %-8s\n",
+		cycleWidth, "Cycle", clusterWidth, "Cluster", "ZTA MAU", "IG MAU", "MWI", "Logins")
+	output += strings.Repeat("-", cycleWidth+2+clusterWidth+2+8+2+8+2+6+2+8) + "\n"
+	for _, row := range rc.clusterTotals {
+		output += fmt.Sprintf("%-*s  %-*s  %-8d  %-8d  %-6d  %-8d\n",
+			cycleWidth, row.Cycle, clusterWidth, row.Cluster, row.ZTA, row.IG, row.MWI, row.Logins)
+	}
+	return output + "\n"
+}
+
+// clusterCSVPath is where a multi-cluster CSV report writes its per-cluster
+// totals.
+func clusterCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_clusters.csv"
+}
+
+// writeClusterCSV writes the per-cluster section as its own CSV file.
+func (rc *reportContext) writeClusterCSV() {
+	file, err := os.OpenFile(clusterCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open cluster CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"cycle", "cluster", "ztamau_users", "igmau_users", "mwi_bots", "successful_logins"}); err != nil {
+		log.Fatalf("Failed to write cluster CSV: %v", err)
+	}
+	for _, row := range rc.clusterTotals {
+		record := []string{row.Cycle, row.Cluster,
+			fmt.Sprint(row.ZTA), fmt.Sprint(row.IG), fmt.Sprint(row.MWI), fmt.Sprint(row.Logins)}
+		if err := w.Write(record); err != nil {
+			log.Fatalf("Failed to write cluster CSV: %v", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write cluster CSV: %v", err)
+	}
+	log.Printf("[INFO] Per-cluster totals written to %s", clusterCSVPath())
+}
+
+// cycleSummary holds the filtered + counted view of one accumulator.
+type cycleSummary struct {
+	ztaMAUAll     map[string]*UserResourceUsage
+	igMAUAll      map[string]*UserIGUsage
+	ztaHumanCount int
+	igHumanCount  int
+	mwiBotCount   int
+}
+
+// activeUsers returns every user counted in ZTA or IG, bots included.
+func (s cycleSummary) activeUsers() map[string]struct{} {
+	active := make(map[string]struct{}, len(s.ztaMAUAll)+len(s.igMAUAll))
+	for user := range s.ztaMAUAll {
+		active[user] = struct{}{}
+	}
+	for user := range s.igMAUAll {
+		active[user] = struct{}{}
+	}
+	return active
+}
+
+func (a *cycleAccum) summarize() cycleSummary {
+	ztaMAUAll := make(map[string]*UserResourceUsage)
+	for user, usage := range a.userResourceUsage {
+		if usage.SSH > 0 || usage.Kubernetes > 0 || usage.Database > 0 ||
+			usage.Application > 0 || usage.Desktop > 0 {
+			ztaMAUAll[user] = usage
+		}
+	}
+	igMAUAll := make(map[string]*UserIGUsage)
+	for user, usage := range a.userIGUsage {
+		if usage.AccessRequestsCreated > 0 || usage.AccessRequestsReviewed > 0 ||
+			usage.AccessListsMemberships > 0 || usage.AccessListsReviewed > 0 ||
+			usage.SAMLIDPSessions > 0 {
+			igMAUAll[user] = usage
+		}
+	}
+	ztaHumanCount := 0
+	igHumanCount := 0
+	botSet := make(map[string]struct{})
+	for user := range ztaMAUAll {
+		if a.userKind[user] == UserKindBot {
+			botSet[user] = struct{}{}
+		} else {
+			ztaHumanCount++
+		}
+	}
+	for user := range igMAUAll {
+		if a.userKind[user] == UserKindBot {
+			botSet[user] = struct{}{}
+		} else {
+			igHumanCount++
+		}
+	}
+	return cycleSummary{
+		ztaMAUAll:     ztaMAUAll,
+		igMAUAll:      igMAUAll,
+		ztaHumanCount: ztaHumanCount,
+		igHumanCount:  igHumanCount,
+		mwiBotCount:   len(botSet),
+	}
+}
+
+// noGroup is the group of users with no value for -group-by, including users
+// that no longer exist in the cluster.
+const noGroup = "(none)"
+
+// groupRow holds the MAU subtotals of one group within a cycle.
+type groupRow struct {
+	Group string `json:"group"`
+	ZTA   int    `json:"ztamau_users"`
+	IG    int    `json:"igmau_users"`
+	MWI   int    `json:"mwi_bots"`
+}
+
+// lookupUserGroups maps every cluster user to its groups under -group-by:
+// the user's roles, or the values of one of its traits.
+func lookupUserGroups(users []types.User, groupBy string) map[string][]string {
+	out := make(map[string][]string, len(users))
+	for _, u := range users {
+		var values []string
+		if groupBy == "role" {
+			values = u.GetRoles()
+		} else {
+			values = u.GetTraits()[strings.TrimPrefix(groupBy, "trait:")]
+		}
+
+		seen := make(map[string]struct{})
+		var groups []string
+		for _, v := range values {
+			v = strings.TrimSpace(v)
+			if _, ok := seen[v]; ok || v == "" {
+				continue
+			}
+			seen[v] = struct{}{}
+			groups = append(groups, v)
+		}
+		sort.Strings(groups)
+		out[u.GetName()] = groups
+	}
+	return out
+}
+
+// groupTotals splits a cycle's MAU counts by group. A user with several
+// values is counted in each of them, so the rows can add up to more than the
+// cycle total. Users without a value are counted under noGroup, listed last.
+func (rc *reportContext) groupTotals(s cycleSummary, userKind map[string]UserKindLabel) []groupRow {
+	rows := make(map[string]*groupRow)
+	add := func(user string, fn func(r *groupRow)) {
+		groups := rc.userGroups[user]
+		if len(groups) == 0 {
+			groups = []string{noGroup}
+		}
+		for _, g := range groups {
+			if rows[g] == nil {
+				rows[g] = &groupRow{Group: g}
+			}
+			fn(rows[g])
+		}
+	}
+
+	bots := make(map[string]struct{})
+	for user := range s.ztaMAUAll {
+		if userKind[user] == UserKindBot {
+			bots[user] = struct{}{}
+		} else {
+			add(user, func(r *groupRow) { r.ZTA++ })
+		}
+	}
+	for user := range s.igMAUAll {
+		if userKind[user] == UserKindBot {
+			bots[user] = struct{}{}
+		} else {
+			add(user, func(r *groupRow) { r.IG++ })
+		}
+	}
+	for user := range bots {
+		add(user, func(r *groupRow) { r.MWI++ })
+	}
+
+	out := make([]groupRow, 0, len(rows))
+	for _, g := range sortedKeys(rows) {
+		if g != noGroup {
+			out = append(out, *rows[g])
+		}
+	}
+	if r, ok := rows[noGroup]; ok {
+		out = append(out, *r)
+	}
+	return out
+}
+
+// groupLabel is the column heading for -group-by.
+func groupLabel() string {
+	if groupBy == "role" {
+		return "Role"
+	}
+	return strings.TrimPrefix(groupBy, "trait:")
+}
+
+// formatGroupTable renders the per-group subtotals of one cycle.
+func formatGroupTable(rows []groupRow) string {
+	if len(rows) == 0 {
+		return ""
+	}
+
+	groupColWidth := len(groupLabel())
+	for _, r := range rows {
+		if len(r.Group) > groupColWidth {
+			groupColWidth = len(r.Group)
+		}
+	}
+	groupColWidth += 2
+
+	output := fmt.Sprintf("MAU BY %s\n", strings.ToUpper(groupLabel()))
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-8s  %-8s  %-6s\n", groupColWidth, groupLabel(), "ZTA MAU", "IG MAU", "MWI")
+	output += strings.Repeat("-", groupColWidth+2+8+2+8+2+6) + "\n"
+	for _, r := range rows {
+		output += fmt.Sprintf("%-*s  %-8d  %-8d  %-6d\n", groupColWidth, r.Group, r.ZTA, r.IG, r.MWI)
+	}
+	return output + "\n"
+}
+
+func main() {
+	// Command-line flags
+	proxyFlag := flag.String(
+		"proxy",
+		"",
+		"Teleport proxy address, e.g. teleport.example.com:443 (required unless -input is set). Comma-separate several clusters to report on them together.",
+	)
+
+	identityFileFlag := flag.String(
+		"identity_file",
+		"",
+		"Path to Teleport identity file (optional - enables use of an identity file instead of ambient tsh credentials). With several -proxy clusters, one comma-separated file per cluster.",
+	)
+
+	dedupeByFlag := flag.String(
+		"dedupe-by",
+		dedupeBy,
+		"With several -proxy clusters, the identity key that counts a person once across them - username, email (trait) or sso (subject).",
+	)
+
+	formatFlag := flag.String(
+		"format",
+		"text",
+		"Output file type - text, json, csv or html",
+	)
+
+	outputFlag := flag.String(
+		"output",
+		"",
+		"Report file path (default Teleport_Active_Users.<txt|json|csv|html>)",
+	)
+
+	billingDayFlag := flag.Int(
+		"billing-day",
+		0,
+		"Billing cycle anchor day (1-31). When set, the report is aligned to Teleport billing cycles instead of a rolling daysBack window.",
+	)
+
+	cyclesFlag := flag.Int(
+		"cycles",
+		3,
+		"Number of completed cycles to include alongside the in-progress cycle (used with -billing-day or -window month|week unless -from is set).",
+	)
+
+	windowFlag := flag.String(
+		"window",
+		windowMode,
+		"Reporting periods without -billing-day - rolling (one window of daysBack days, or -from/-to), month (calendar months) or week (ISO weeks).",
+	)
+
+	fromFlag := flag.String(
+		"from",
+		"",
+		"First day to report on (YYYY-MM-DD). With -billing-day or -window month|week, every period overlapping -from/-to is reported whole.",
+	)
+
+	toFlag := flag.String(
+		"to",
+		"",
+		"Last day to report on (YYYY-MM-DD, inclusive). Defaults to today.",
+	)
+
+	tzFlag := flag.String(
+		"tz",
+		timezone,
+		"IANA timezone in which days, weeks, months and billing cycles start, e.g. Europe/Berlin.",
+	)
+
+	parallelFlag := flag.Int(
+		"parallel",
+		parallelism,
+		"Number of time shards scanned concurrently.",
+	)
+
+	shardFlag := flag.String(
+		"shard",
+		shardMode,
+		"How the window is split for scanning - day, cycle (requires -billing-day) or none.",
+	)
+
+	limitZTAFlag := flag.Int(
+		"limit-zta",
+		limitZTA,
+		"Licensed ZTA MAU. Exit with status 2 when the actual or projected count exceeds it (0 disables).",
+	)
+
+	limitIGFlag := flag.Int(
+		"limit-ig",
+		limitIG,
+		"Licensed IG MAU. Exit with status 2 when the actual or projected count exceeds it (0 disables).",
+	)
+
+	limitMWIFlag := flag.Int(
+		"limit-mwi",
+		limitMWI,
+		"Licensed MWI bots. Exit with status 2 when the actual or projected count exceeds it (0 disables).",
+	)
+
+	alertWebhookFlag := flag.String(
+		"alert-webhook",
+		alertWebhook,
+		"Optional URL that receives a JSON POST when a licence limit is exceeded.",
+	)
+
+	dormantFlag := flag.Int(
+		"dormant-days",
+		dormantDays,
+		"List cluster users with no ZTA or IG activity in this many days, with last seen and roles (0 disables).",
+	)
+
+	seriesFlag := flag.Bool(
+		"series",
+		seriesMode,
+		"Add daily and weekly distinct-user counts (with new vs returning users) to the JSON report and a _series.csv file.",
+	)
+
+	securityFlag := flag.Bool(
+		"security",
+		securityMode,
+		"Add a security section with failed logins, login methods, MFA and device trust per user. MAU counts are unchanged.",
+	)
+
+	sessionsFlag := flag.Bool(
+		"sessions",
+		sessionMode,
+		"Pair session start and end events to report connected time and session counts per user and category. MAU counts are unchanged.",
+	)
+
+	detailFlag := flag.Bool(
+		"detail",
+		detailMode,
+		"Record the distinct resources each user accessed, with first and last seen times.",
+	)
+
+	groupByFlag := flag.String(
+		"group-by",
+		groupBy,
+		"Add MAU subtotals per group - role, or trait:<name> (e.g. trait:department).",
+	)
+
+	reconcileFlag := flag.String(
+		"reconcile",
+		reconcilePath,
+		"Optional CSV or JSON file of per-cycle billed figures (cycle_start, zta_mau, ig_mau, mwi_bots) to compare with the report, listing the users behind each difference. Requires -billing-day.",
+	)
+
+	mappingFlag := flag.String(
+		"mapping",
+		mappingPath,
+		"Optional JSON file mapping audit event types and codes to report columns, replacing the built-in mapping.",
+	)
+
+	printMappingFlag := flag.Bool(
+		"print-mapping",
+		false,
+		"Print the event mapping in -mapping file format and exit.",
+	)
+
+	inputFlag := flag.String(
+		"input",
+		strings.Join(inputPaths, ","),
+		"Comma-separated audit event files (JSON lines, optionally .gz) or directories to read instead of querying the cluster.",
+	)
+
+	pseudonymizeFlag := flag.String(
+		"pseudonymize",
+		pseudonymKeyPath,
+		"Replace usernames in every report with stable keyed-HMAC pseudonyms, using the secret key in this file (created if missing).",
+	)
+
+	pseudonymMapFlag := flag.String(
+		"pseudonym-map",
+		pseudonymMapPath,
+		"With -pseudonymize, the CSV file that maps pseudonyms back to usernames (required). Store it apart from the reports.",
+	)
+
+	storeFlag := flag.String(
+		"store",
+		storePath,
+		"Optional SQLite file caching per-day aggregates between runs. Only new events are fetched.",
+	)
+
+	flag.Parse()
+
+	mappingPath = *mappingFlag
+	if mappingPath != "" {
+		m, err := loadEventMapping(mappingPath)
+		if err != nil {
+			log.Fatalf("invalid -mapping %s: %v", mappingPath, err)
+		}
+		mapping = m
+	}
+	if *printMappingFlag {
+		data, err := json.MarshalIndent(map[string]interface{}{"rules": mapping.rules()}, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to print mapping: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	inputPaths = splitList(*inputFlag)
+	offline := len(inputPaths) > 0
+
+	// Offline reports never contact the cluster; -proxy then only labels
+	// the report.
+	proxies := splitList(*proxyFlag)
+	var targets []clusterTarget
+	if offline {
+		teleportProxyURL = strings.Join(proxies, ", ")
+		if teleportProxyURL == "" {
+			teleportProxyURL = "offline: " + strings.Join(inputPaths, ", ")
+		}
+	} else {
+		if len(proxies) == 0 {
+			log.Fatalf("-proxy is required (e.g. -proxy teleport.example.com:443). Run with -h for usage.")
+		}
+		for _, proxy := range proxies {
+			canonicalProxy, err := preflightProxy(proxy)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			targets = append(targets, clusterTarget{Proxy: canonicalProxy})
+		}
+		teleportProxyURL = targets[0].Proxy
+		for _, t := range targets[1:] {
+			teleportProxyURL += ", " + t.Proxy
+		}
+	}
+	dedupeBy = strings.ToLower(strings.TrimSpace(*dedupeByFlag))
+	if dedupeBy != "username" && dedupeBy != "email" && dedupeBy != "sso" {
+		log.Fatalf("invalid -dedupe-by %q (expected username, email or sso)", dedupeBy)
+	}
+
+	// Output format handling
+	reportFormat = strings.ToLower(strings.TrimSpace(*formatFlag))
+	switch reportFormat {
+	case "text", "json", "csv", "html":
+	default:
+		log.Fatalf("invalid -format %q (expected text, json, csv or html)", reportFormat)
+	}
+	outputPath = *outputFlag
+
+	identityFiles := splitList(*identityFileFlag)
+	switch {
+	case offline:
+		// Reading files needs no credentials.
+		identityFiles = nil
+	case len(targets) > 1:
+		// A tsh profile is only logged in to one cluster at a time.
+		if len(identityFiles) != len(targets) {
+			log.Fatalf("with %d -proxy clusters, -identity_file needs one file per cluster in the same order (got %d)",
+				len(targets), len(identityFiles))
+		}
+	case len(identityFiles) > 1:
+		log.Fatalf("-identity_file lists %d files for a single -proxy cluster", len(identityFiles))
+	case len(identityFiles) == 1:
+		useIdentityFile = true
+		identityFilePath = identityFiles[0]
+	case useIdentityFile:
+		identityFiles = []string{identityFilePath}
+	default:
+		if err := preflightTshProfile(targets[0].Proxy); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	for i, path := range identityFiles {
+		// Validation
+		if _, err := os.Stat(path); err != nil {
+			log.Fatalf("identity file not accessible: %v", err)
+		}
+		targets[i].IdentityFile = path
+	}
+
+	billingDay := *billingDayFlag
+	if billingDay < 0 || billingDay > 31 {
+		log.Fatalf("invalid -billing-day %d (expected 1-31, or 0 to disable)", billingDay)
+	}
+	billingDayAnchor = billingDay
+	cyclesCount := *cyclesFlag
+	if cyclesCount < 0 {
+		log.Fatalf("invalid -cycles %d (must be >= 0)", cyclesCount)
+	}
+	windowMode = strings.ToLower(strings.TrimSpace(*windowFlag))
+	if windowMode != "rolling" && windowMode != "month" && windowMode != "week" {
+		log.Fatalf("invalid -window %q (expected rolling, month or week)", windowMode)
+	}
+	if billingDay > 0 && windowMode != "rolling" {
+		log.Fatalf("-window %s cannot be combined with -billing-day", windowMode)
+	}
+
+	// Every day boundary below, including those of events read with -input,
+	// is in the -tz timezone, so it is loaded first.
+	timezone = strings.TrimSpace(*tzFlag)
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Fatalf("invalid -tz %q: %v", timezone, err)
+	}
+	reportLocation = loc
+	var fromDay, toDay time.Time
+	if *fromFlag != "" {
+		if fromDay, err = time.ParseInLocation("2006-01-02", *fromFlag, reportLocation); err != nil {
+			log.Fatalf("invalid -from %q (expected YYYY-MM-DD)", *fromFlag)
+		}
+	}
+	if *toFlag != "" {
+		if toDay, err = time.ParseInLocation("2006-01-02", *toFlag, reportLocation); err != nil {
+			log.Fatalf("invalid -to %q (expected YYYY-MM-DD)", *toFlag)
+		}
+	}
+	if !fromDay.IsZero() && !toDay.IsZero() && toDay.Before(fromDay) {
+		log.Fatalf("-to %s is before -from %s", *toFlag, *fromFlag)
+	}
+
+	parallelism = *parallelFlag
+	if parallelism < 1 {
+		log.Fatalf("invalid -parallel %d (must be >= 1)", parallelism)
+	}
+	shardMode = strings.ToLower(strings.TrimSpace(*shardFlag))
+	if shardMode != "day" && shardMode != "cycle" && shardMode != "none" {
+		log.Fatalf("invalid -shard %q (expected day, cycle or none)", shardMode)
+	}
+	if shardMode == "cycle" && billingDay == 0 && windowMode == "rolling" {
+		log.Fatalf("-shard cycle requires -billing-day or -window month|week")
+	}
+	storePath = *storeFlag
+	detailMode = *detailFlag
+	securityMode = *securityFlag
+	sessionMode = *sessionsFlag
+	seriesMode = *seriesFlag
+	dormantDays = *dormantFlag
+	if dormantDays < 0 {
+		log.Fatalf("invalid -dormant-days %d (must be >= 0)", dormantDays)
+	}
+	limitZTA, limitIG, limitMWI = *limitZTAFlag, *limitIGFlag, *limitMWIFlag
+	if limitZTA < 0 || limitIG < 0 || limitMWI < 0 {
+		log.Fatalf("invalid licence limit (-limit-zta, -limit-ig and -limit-mwi must be >= 0)")
+	}
+	alertWebhook = strings.TrimSpace(*alertWebhookFlag)
+	groupBy = strings.TrimSpace(*groupByFlag)
+	if groupBy != "" && groupBy != "role" && (!strings.HasPrefix(groupBy, "trait:") || groupBy == "trait:") {
+		log.Fatalf("invalid -group-by %q (expected role or trait:<name>)", groupBy)
+	}
+
+	pseudonymKeyPath = strings.TrimSpace(*pseudonymizeFlag)
+	pseudonymMapPath = strings.TrimSpace(*pseudonymMapFlag)
+	var pseudonyms *pseudonymizer
+	if pseudonymKeyPath != "" {
+		// The map is never written next to the reports by default.
+		if pseudonymMapPath == "" {
+			log.Fatalf("-pseudonymize requires -pseudonym-map, a file kept apart from the reports")
+		}
+		if pseudonyms, err = loadPseudonymizer(pseudonymKeyPath); err != nil {
+			log.Fatalf("invalid -pseudonymize key %s: %v", pseudonymKeyPath, err)
+		}
+	}
+
+	reconcilePath = strings.TrimSpace(*reconcileFlag)
+	var billed []billedCycle
+	if reconcilePath != "" {
+		if billingDay == 0 {
+			log.Fatalf("-reconcile compares billing cycles and requires -billing-day")
+		}
+		var err error
+		billed, err = loadBilledCycles(reconcilePath)
+		if err != nil {
+			log.Fatalf("invalid -reconcile %s: %v", reconcilePath, err)
+		}
+	}
+
+	if offline && storePath != "" {
+		log.Fatalf("-store cannot be combined with -input")
+	}
+	if offline && groupBy != "" {
+		log.Fatalf("-group-by needs the cluster's user list and cannot be combined with -input")
+	}
+	if offline && dormantDays > 0 {
+		log.Fatalf("-dormant-days needs the cluster's user list and cannot be combined with -input")
+	}
+
+	ctx := context.Background()
+
+	// Event types to track for both ZTA MAU and IG MAU
+	eventTypes := mapping.eventTypes()
+	if _, ok := mapping["user.login"]; securityMode && !ok {
+		// Failed logins are reported even when logins do not count.
+		eventTypes = append(eventTypes, "user.login")
+	}
+	if sessionMode {
+		// Session ends pair with the starts the mapping already counts.
+		for _, t := range sortedKeys(sessionEvents) {
+			if _, ok := mapping[t]; !ok {
+				eventTypes = append(eventTypes, t)
+			}
+		}
+	}
+
+	// Offline inputs are read before the window is known: the newest event
+	// in them stands in for "now", so an export of any age yields a report.
+	var days dailyAccums
+	now := time.Now().UTC()
+	if offline {
+		var latest time.Time
+		days, latest, err = readInputs(inputPaths, eventTypes)
+		if err != nil {
+			log.Fatalf("Failed to read -input: %v", err)
+		}
+		if latest.IsZero() {
+			log.Fatalf("No tracked audit events found in -input %s", strings.Join(inputPaths, ", "))
+		}
+		now = latest
+		log.Printf("[INFO] Offline mode: reporting up to the newest event, %s", now.Format(time.RFC3339))
+	}
+
+	// Define the time range and reporting periods. -to ends the window at
+	// the end of that day, but periods overlapping it are reported whole.
+	end := now
+	if !toDay.IsZero() && toDay.AddDate(0, 0, 1).Before(now) {
+		end = toDay.AddDate(0, 0, 1)
+	}
+	var (
+		windowStart, windowEnd time.Time
+		cycles                 []cycleBounds
+	)
+	if periodOf := periodFunc(billingDay); periodOf != nil {
+		if fromDay.IsZero() {
+			cycles = lastNPeriods(end.Add(-time.Nanosecond), cyclesCount, periodOf)
+		} else {
+			cycles = periodsBetween(fromDay, end, periodOf)
+		}
+		if len(cycles) == 0 {
+			log.Fatalf("-from %s is after the end of the reporting window", *fromFlag)
+		}
+		for i := range cycles {
+			cycles[i].InProgress = cycles[i].End.After(now)
+		}
+		windowStart = cycles[0].Start
+		windowEnd = cycles[len(cycles)-1].End
+		if windowEnd.After(now) {
+			windowEnd = now
+		}
+		if billingDay > 0 {
+			log.Printf("[INFO] Billing-cycle mode: anchor=%d, %d cycle(s) from %s to %s",
+				billingDay, len(cycles), windowStart.Format("2006-01-02"), windowEnd.In(reportLocation).Format("2006-01-02"))
+		} else {
+			log.Printf("[INFO] Reporting %d %s from %s to %s",
+				len(cycles), periodName(), windowStart.Format("2006-01-02"), windowEnd.In(reportLocation).Format("2006-01-02"))
+		}
+		if !offline && now.Sub(windowStart) > 90*24*time.Hour {
+			log.Printf("[WARN] Requested window spans %.0f days; older cycles may be empty due to audit log retention.",
+				now.Sub(windowStart).Hours()/24)
+		}
+	} else {
+		windowStart = end.AddDate(0, 0, -daysBack)
+		if !fromDay.IsZero() {
+			windowStart = fromDay
+		}
+		windowEnd = end
+		if !windowStart.Before(windowEnd) {
+			log.Fatalf("-from %s is after the end of the reporting window", *fromFlag)
+		}
+	}
+
+	// -dormant-days looks back N days from the end of the window, which
+	// may reach further than the report itself.
+	scanStart := windowStart
+	if lookback := dormantCutoff(windowEnd); dormantDays > 0 && lookback.Before(scanStart) {
+		scanStart = lookback
+		log.Printf("[INFO] Scanning from %s so that -dormant-days %d sees each user's last activity",
+			scanStart.Format("2006-01-02"), dormantDays)
+	}
+
+	var runs []clusterRun
+	if offline {
+		days.trim(scanStart, windowEnd)
+		runs = []clusterRun{{Name: teleportProxyURL, Days: days}}
+	} else {
+		runs, err = scanClusters(ctx, targets, scanStart, windowEnd, cycles, eventTypes)
+		if err != nil {
+			log.Fatalf("Failed to scan %v", err)
+		}
+	}
+
+	// Everything the writers show besides the accumulators
+	rc := &reportContext{}
+
+	// The rolling window is reported as a single pseudo-cycle so that the
+	// csv and html writers handle both modes the same way.
+	reportCycles := cycles
+	if cycles == nil {
+		label := fmt.Sprintf("Last %d days", daysBack)
+		if !fromDay.IsZero() || !toDay.IsZero() {
+			label = fmt.Sprintf("%s - %s", windowStart.In(reportLocation).Format("2 Jan 2006"),
+				windowEnd.Add(-time.Nanosecond).In(reportLocation).Format("2 Jan 2006"))
+		}
+		reportCycles = []cycleBounds{{
+			Start: windowStart,
+			End:   windowEnd,
+			Label: label,
+		}}
+		rc.window = label
+	}
+
+	// Several clusters are classified one by one, then merged by identity
+	// so that each person is counted once in the combined totals.
+	var clusterUsers []types.User
+	if len(runs) == 1 {
+		days, clusterUsers = runs[0].Days, runs[0].Users
+		if groupBy != "" {
+			rc.userGroups = lookupUserGroups(clusterUsers, groupBy)
+		}
+	} else {
+		for i := range runs {
+			runs[i].classify()
+		}
+		days, rc.classifications = combineClusters(runs)
+		if groupBy != "" {
+			rc.userGroups = combineGroups(runs)
+		}
+		log.Printf("[INFO] Combined %d clusters into %d identities (-dedupe-by %s)", len(runs), len(rc.classifications), dedupeBy)
+	}
+	if groupBy != "" {
+		log.Printf("[INFO] Loaded -group-by %s for %d user(s)", groupBy, len(rc.userGroups))
+	}
+
+	// Days scanned only for -dormant-days stay out of the report.
+	lookbackDays := days
+	if scanStart.Before(windowStart) {
+		days = make(dailyAccums, len(lookbackDays))
+		for day, a := range lookbackDays {
+			days[day] = a
+		}
+		days.trim(windowStart, windowEnd)
+		if len(runs) > 1 {
+			active := days.fold(windowStart, windowEnd).userKind
+			for key := range rc.classifications {
+				if _, ok := active[key]; !ok {
+					delete(rc.classifications, key)
+				}
+			}
+		}
+	}
+
+	accums := make([]*cycleAccum, len(reportCycles))
+	summaries := make([]cycleSummary, len(reportCycles))
+	for i, c := range reportCycles {
+		accums[i] = days.fold(c.Start, c.End)
+	}
+	if len(runs) == 1 {
+		rc.classifications = classifyUsers(clusterUsers, accums)
+	}
+	for i := range accums {
+		summaries[i] = accums[i].summarize()
+	}
+	if len(runs) > 1 {
+		rc.clusterTotals = buildClusterTotals(runs, reportCycles, accums)
+	}
+	if securityMode {
+		rc.ssoConnectors = lookupSSOConnectors(runs)
+	}
+	if sessionMode {
+		attributeSessions(days.fold(windowStart, windowEnd).sessions, reportCycles, accums, windowStart, windowEnd)
+	}
+	if reconcilePath != "" {
+		rc.reconciliation = reconcile(billed, reportCycles, summaries, days, rc.classifications)
+		for _, row := range rc.reconciliation {
+			if row.Difference != 0 {
+				log.Printf("[WARN] %s %s: billed %d, reported %d (%+d); see the report for candidate users",
+					row.Cycle, row.Metric, row.Billed, row.Reported, row.Difference)
+			}
+		}
+	}
+	if n := len(rc.heuristicClassifications()); n > 0 {
+		log.Printf("[WARN] %d user(s) were classified by a fallback heuristic; see the report for the list", n)
+	}
+
+	if dormantDays > 0 {
+		if len(runs) == 1 {
+			rc.dormantUsers = findDormantUsers(clusterUsers, lookbackDays, windowEnd)
+		} else {
+			rc.dormantUsers = findDormantIdentities(runs, lookbackDays, windowEnd)
+		}
+		log.Printf("[INFO] %d user(s) had no ZTA/IG activity in the last %d days", len(rc.dormantUsers), dormantDays)
+	}
+
+	if seriesMode {
+		rc.dailySeries = buildSeries(days, rc.classifications, windowStart, windowEnd, false)
+		rc.weeklySeries = buildSeries(days, rc.classifications, windowStart, windowEnd, true)
+	}
+
+	// The forecast covers the in-progress cycle; in rolling-window mode only
+	// the actual counts are checked against the licence limits.
+	if cycles != nil {
+		rc.forecasts = buildForecasts(days, rc.classifications, cycles, summaries, windowEnd)
+	} else if limitZTA > 0 || limitIG > 0 || limitMWI > 0 {
+		rc.forecasts = buildForecasts(days, rc.classifications, nil, summaries, windowEnd)
+	}
+
+	// Pseudonyms replace usernames only now, once every lookup by name
+	// (cluster users, groups, dormant users) is done.
+	if pseudonyms != nil {
+		accums, summaries = pseudonyms.apply(accums, rc)
+		if err := pseudonyms.writeMap(pseudonymMapPath); err != nil {
+			log.Fatalf("Failed to write -pseudonym-map %s: %v", pseudonymMapPath, err)
+		}
+		log.Printf("[INFO] Pseudonym map written to %s; keep it apart from the reports", pseudonymMapPath)
+	}
+
+	switch {
+	case reportFormat == "csv":
+		rc.writeCSVReport(reportCycles, accums, summaries)
+	case reportFormat == "html":
+		rc.writeHTMLReport(reportCycles, accums, summaries)
+	case cycles != nil:
+		rc.writePerCycleReport(cycles, accums, summaries)
+	default:
+		s := summaries[0]
+		rc.writeUserReport(s.ztaMAUAll, s.igMAUAll, accums[0].userKind, accums[0].totalLogins, s.ztaHumanCount, s.igHumanCount, s.mwiBotCount, accums[0].resources, accums[0].signals, accums[0].connected)
+	}
+
+	if seriesMode {
+		rc.writeSeriesCSV()
+	}
+	if dormantDays > 0 && reportFormat == "csv" {
+		rc.writeDormantCSV()
+	}
+	if rc.clusterTotals != nil && reportFormat == "csv" {
+		rc.writeClusterCSV()
+	}
+	if reconcilePath != "" && reportFormat == "csv" {
+		rc.writeReconcileCSV()
+	}
+
+	if exceeded := rc.exceededLimits(); len(exceeded) > 0 {
+		for _, f := range exceeded {
+			log.Printf("[WARN] %s %s: actual %d, projected %s, limit %d",
+				f.Metric, f.Status, f.Actual, formatOptional(f.Projected), f.Limit)
+		}
+		if alertWebhook != "" {
+			if err := postLimitAlert(exceeded); err != nil {
+				log.Printf("[ERROR] Failed to post licence alert to %s: %v", alertWebhook, err)
+			} else {
+				log.Printf("[INFO] Licence alert posted to %s", alertWebhook)
+			}
+		}
+		os.Exit(2)
+	}
+}
+
+// dormantUser is a cluster user with no ZTA/IG activity in -dormant-days.
+type dormantUser struct {
+	User     string        `json:"user"`
+	Kind     UserKindLabel `json:"kind"`
+	Roles    []string      `json:"roles"`
+	LastSeen string        `json:"last_seen,omitempty"` // empty if not seen in the scanned window
+	Created  string        `json:"created,omitempty"`
+}
+
+// dormantCutoff returns the first day that still counts as recent activity
+// for -dormant-days: N days before the last day of a window ending at the
+// exclusive end, which is often midnight.
+func dormantCutoff(end time.Time) time.Time {
+	return dayStart(end.Add(-time.Nanosecond)).AddDate(0, 0, -dormantDays)
+}
+
+// findDormantUsers joins the cluster users with the per-day activity and
+// returns, sorted by name, those whose last ZTA/IG activity is more than
+// -dormant-days before the last day of the window ending at end, or who were
+// not active in the scanned window.
+func findDormantUsers(clusterUsers []types.User, days dailyAccums, end time.Time) []dormantUser {
+	lastSeen := lastActiveDays(days)
+	cutoff := dormantCutoff(end)
+	var out []dormantUser
+	for _, u := range clusterUsers {
+		seen, ok := lastSeen[u.GetName()]
+		if ok && !seen.Before(cutoff) {
+			continue
+		}
+		out = append(out, newDormantUser(u, seen, ok))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	return out
+}
+
+// lastActiveDays returns the last day each user was counted in ZTA or IG.
+func lastActiveDays(days dailyAccums) map[string]time.Time {
+	lastSeen := make(map[string]time.Time)
+	for _, day := range days.sortedDays() {
+		for user := range days[day].summarize().activeUsers() {
+			lastSeen[user] = day
+		}
+	}
+	return lastSeen
+}
+
+// newDormantUser builds the report row for a dormant cluster user. seen is
+// its last active day, if ok.
+func newDormantUser(u types.User, seen time.Time, ok bool) dormantUser {
+	kind := UserKindHuman
+	if u.IsBot() {
+		kind = UserKindBot
+	}
+	roles := append([]string{}, u.GetRoles()...)
+	sort.Strings(roles)
+
+	d := dormantUser{User: u.GetName(), Kind: kind, Roles: roles}
+	if ok {
+		d.LastSeen = seen.Format("2006-01-02")
+	}
+	if created := u.GetCreatedBy().Time; !created.IsZero() {
+		d.Created = created.In(reportLocation).Format("2006-01-02")
+	}
+	return d
+}
+
+// formatDormantTable renders the -dormant-days section.
+func (rc *reportContext) formatDormantTable() string {
+	output := fmt.Sprintf("DORMANT USERS (no ZTA/IG activity in %d days)\n", dormantDays)
+	output += "-------------------------------------------------\n"
+	if len(rc.dormantUsers) == 0 {
+		return output + "(none)\n\n"
+	}
+
+	userColWidth := 4
+	for _, d := range rc.dormantUsers {
+		if len(d.User) > userColWidth {
+			userColWidth = len(d.User)
+		}
+	}
+	userColWidth += 2
+
+	output += fmt.Sprintf("%-*s  %-6s  %-11s  %-10s  %s\n", userColWidth, "User", "Kind", "Last Seen", "Created", "Roles")
+	output += strings.Repeat("-", userColWidth+2+6+2+11+2+10+2+20) + "\n"
+	for _, d := range rc.dormantUsers {
+		lastSeen := d.LastSeen
+		if lastSeen == "" {
+			lastSeen = "never*"
+		}
+		created := d.Created
+		if created == "" {
+			created = "-"
+		}
+		output += fmt.Sprintf("%-*s  %-6s  %-11s  %-10s  %s\n",
+			userColWidth, d.User, d.Kind, lastSeen, created, strings.Join(d.Roles, ", "))
+	}
+	return output + "* not seen in the scanned window\n\n"
+}
+
+// dormantCSVPath is where -dormant-days writes its CSV next to a CSV report.
+func dormantCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_dormant.csv"
+}
+
+// writeDormantCSV writes the -dormant-days section as its own CSV file.
+func (rc *reportContext) writeDormantCSV() {
+	file, err := os.OpenFile(dormantCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open dormant-user CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"user", "kind", "last_seen", "created", "roles"}); err != nil {
+		log.Fatalf("Failed to write dormant-user CSV: %v", err)
+	}
+	for _, d := range rc.dormantUsers {
+		if err := w.Write([]string{d.User, string(d.Kind), d.LastSeen, d.Created, strings.Join(d.Roles, ";")}); err != nil {
+			log.Fatalf("Failed to write dormant-user CSV: %v", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write dormant-user CSV: %v", err)
+	}
+	log.Printf("[INFO] Dormant users written to %s", dormantCSVPath())
+}
+
+// seriesPoint is one day or week of the -series time series.
+type seriesPoint struct {
+	Period    string `json:"-"`
+	Start     string `json:"start"`
+	End       string `json:"end"`               // last day counted
+	Partial   bool   `json:"partial,omitempty"` // a week cut short by the window
+	ZTA       int    `json:"ztamau_users"`
+	IG        int    `json:"igmau_users"`
+	MWI       int    `json:"mwi_bots"`
+	New       int    `json:"new_users"`
+	Returning int    `json:"returning_users"`
+}
+
+// buildSeries returns the distinct ZTA, IG and MWI users of every day (or
+// every Monday-based week) in [from, to), including empty ones. The first
+// and last weeks are clipped to the window and marked partial when they
+// cover fewer than seven days. A user active in a period is new the first
+// time it appears in the scanned range and returning after that. Active
+// users are everyone counted in ZTA, IG or MWI, so bots are included.
+func buildSeries(days dailyAccums, classifications map[string]userClassification, from, to time.Time, weekly bool) []seriesPoint {
+	period, start, step := "day", dayStart(from), 1
+	if weekly {
+		period, step = "week", 7
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+
+	// to is exclusive, often midnight: the last day is the one before it.
+	first, last := dayStart(from), dayStart(to.Add(-time.Nanosecond))
+	seen := make(map[string]struct{})
+	var out []seriesPoint
+	for p := start; !p.After(last); p = p.AddDate(0, 0, step) {
+		// Clip the period to the days of the window.
+		pFirst, pLast := p, p.AddDate(0, 0, step-1)
+		if pFirst.Before(first) {
+			pFirst = first
+		}
+		if pLast.After(last) {
+			pLast = last
+		}
+
+		a := newCycleAccum()
+		n := 0
+		for d := pFirst; !d.After(pLast); d = d.AddDate(0, 0, 1) {
+			if day, ok := days[d]; ok {
+				a.merge(day)
+			}
+			n++
+		}
+		a.applyKinds(classifications)
+		s := a.summarize()
+
+		point := seriesPoint{
+			Period:  period,
+			Start:   pFirst.Format("2006-01-02"),
+			End:     pLast.Format("2006-01-02"),
+			Partial: n < step,
+			ZTA:     s.ztaHumanCount,
+			IG:      s.igHumanCount,
+			MWI:     s.mwiBotCount,
+		}
+		for user := range s.activeUsers() {
+			if _, ok := seen[user]; ok {
+				point.Returning++
+			} else {
+				point.New++
+				seen[user] = struct{}{}
+			}
+		}
+		out = append(out, point)
+	}
+	return out
+}
+
+// seriesCSVPath is where -series writes its CSV next to the report.
+func seriesCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_series.csv"
+}
+
+// writeSeriesCSV writes the daily and weekly series to one CSV file.
+func (rc *reportContext) writeSeriesCSV() {
+	file, err := os.OpenFile(seriesCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open series CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	header := []string{"period", "start", "end", "partial", "ztamau_users", "igmau_users", "mwi_bots", "new_users", "returning_users"}
+	if err := w.Write(header); err != nil {
+		log.Fatalf("Failed to write series CSV: %v", err)
+	}
+	for _, series := range [][]seriesPoint{rc.dailySeries, rc.weeklySeries} {
+		for _, p := range series {
+			row := []string{
+				p.Period, p.Start, p.End, strconv.FormatBool(p.Partial), fmt.Sprint(p.ZTA), fmt.Sprint(p.IG), fmt.Sprint(p.MWI),
+				fmt.Sprint(p.New), fmt.Sprint(p.Returning),
+			}
+			if err := w.Write(row); err != nil {
+				log.Fatalf("Failed to write series CSV: %v", err)
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write series CSV: %v", err)
+	}
+	log.Printf("[INFO] Time series written to %s", seriesCSVPath())
+}
+
+// Metrics checked against licence limits.
+const (
+	metricZTA = "ZTA MAU"
+	metricIG  = "IG MAU"
+	metricMWI = "MWI"
+)
+
+// Forecast statuses.
+const (
+	statusNoLimit            = "no limit"
+	statusOK                 = "ok"
+	statusProjectedOverLimit = "projected over limit"
+	statusOverLimit          = "over limit"
+)
+
+// mauCounts holds the three billed counts of a cycle.
+type mauCounts struct {
+	ZTA, IG, MWI int
+}
+
+func (s cycleSummary) counts() mauCounts {
+	return mauCounts{ZTA: s.ztaHumanCount, IG: s.igHumanCount, MWI: s.mwiBotCount}
+}
+
+func (m mauCounts) get(metric string) int {
+	switch metric {
+	case metricZTA:
+		return m.ZTA
+	case metricIG:
+		return m.IG
+	default:
+		return m.MWI
+	}
+}
+
+// forecastRow is the forecast and licence check for one metric.
+type forecastRow struct {
+	Metric    string `json:"metric"`
+	Actual    int    `json:"actual"`
+	Projected *int   `json:"projected,omitempty"`
+	Trend     *int   `json:"trend,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	Status    string `json:"status"`
+}
+
+// cumulativeCounts returns, for each day of c up to and including the day of
+// through, the distinct users seen from the start of c to the end of that day.
+func cumulativeCounts(days dailyAccums, classifications map[string]userClassification, c cycleBounds, through time.Time) []mauCounts {
+	var out []mauCounts
+	running := newCycleAccum()
+	for day := c.Start; day.Before(c.End) && !day.After(through); day = day.AddDate(0, 0, 1) {
+		if d, ok := days[day]; ok {
+			running.merge(d)
+			running.applyKinds(classifications)
+		}
+		out = append(out, running.summarize().counts())
+	}
+	return out
+}
+
+// buildForecasts checks the latest cycle against the licence limits and, with
+// billing cycles, projects where the in-progress cycle will end.
+//
+// The projection follows the daily unique-user accrual of the completed
+// cycles: if by day k they had on average reached fraction f of their final
+// count, the in-progress cycle is projected at actual/f. Without usable past
+// cycles it falls back to a linear extrapolation over the cycle length. The
+// trend is a least-squares line through the completed cycle totals, extended
+// to the in-progress cycle.
+func buildForecasts(days dailyAccums, classifications map[string]userClassification, cycles []cycleBounds, summaries []cycleSummary, now time.Time) []forecastRow {
+	limits := map[string]int{metricZTA: limitZTA, metricIG: limitIG, metricMWI: limitMWI}
+	actual := summaries[len(summaries)-1].counts()
+
+	var (
+		current []mauCounts
+		past    [][]mauCounts
+		finals  []mauCounts
+	)
+	project := len(cycles) > 0 && cycles[len(cycles)-1].InProgress
+	if project {
+		current = cumulativeCounts(days, classifications, cycles[len(cycles)-1], now)
+		for i, c := range cycles[:len(cycles)-1] {
+			past = append(past, cumulativeCounts(days, classifications, c, c.End))
+			finals = append(finals, summaries[i].counts())
+		}
+	}
+
+	var out []forecastRow
+	for _, metric := range []string{metricZTA, metricIG, metricMWI} {
+		row := forecastRow{Metric: metric, Actual: actual.get(metric), Limit: limits[metric]}
+
+		if project && len(current) > 0 {
+			elapsed := len(current)
+			cycleDays := int(cycles[len(cycles)-1].End.Sub(cycles[len(cycles)-1].Start).Hours()/24 + 0.5)
+
+			var fractions []float64
+			for i, curve := range past {
+				final := finals[i].get(metric)
+				if final == 0 || len(curve) == 0 {
+					continue
+				}
+				k := elapsed
+				if k > len(curve) {
+					k = len(curve)
+				}
+				fractions = append(fractions, float64(curve[k-1].get(metric))/float64(final))
+			}
+
+			var projected, avg float64
+			for _, f := range fractions {
+				avg += f / float64(len(fractions))
+			}
+			if avg > 0 {
+				projected = float64(row.Actual) / avg
+				row.Method = "accrual curve"
+			} else {
+				projected = float64(row.Actual) * float64(cycleDays) / float64(elapsed)
+				row.Method = "linear"
+			}
+			p := int(projected + 0.5)
+			if p < row.Actual {
+				p = row.Actual
+			}
+			row.Projected = &p
+
+			if len(finals) >= 2 {
+				t := trendNext(finals, metric)
+				row.Trend = &t
+			}
+		}
+
+		switch {
+		case row.Limit == 0:
+			row.Status = statusNoLimit
+		case row.Actual > row.Limit:
+			row.Status = statusOverLimit
+		case row.Projected != nil && *row.Projected > row.Limit:
+			row.Status = statusProjectedOverLimit
+		default:
+			row.Status = statusOK
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// trendNext fits a least-squares line through the completed cycle totals and
+// returns its value for the next cycle, floored at zero.
+func trendNext(finals []mauCounts, metric string) int {
+	n := float64(len(finals))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, f := range finals {
+		x, y := float64(i), float64(f.get(metric))
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept := (sumY - slope*sumX) / n
+	next := int(intercept + slope*n + 0.5)
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+
+// exceededLimits returns the forecast rows that are over, or projected over,
+// their licence limit.
+func (rc *reportContext) exceededLimits() []forecastRow {
+	var out []forecastRow
+	for _, f := range rc.forecasts {
+		if f.Status == statusOverLimit || f.Status == statusProjectedOverLimit {
+			out = append(out, f)
 		}
-		if len(rawEvents) == 0 {
-			break
+	}
+	return out
+}
+
+// postLimitAlert sends the exceeded limits to -alert-webhook as JSON.
+func postLimitAlert(exceeded []forecastRow) error {
+	payload := map[string]interface{}{
+		"teleport_proxy_url": teleportProxyURL,
+		"timestamp":          time.Now().UTC().Format(time.RFC3339),
+		"billing_anchor_day": billingDayAnchor,
+		"alerts":             exceeded,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Post(alertWebhook, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// formatOptional renders an optional count, or "-" when it is unset.
+func formatOptional(v *int) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(*v)
+}
+
+// formatForecastTable renders the forecast and licence checks.
+func (rc *reportContext) formatForecastTable() string {
+	if len(rc.forecasts) == 0 {
+		return ""
+	}
+
+	output := "FORECAST AND LICENCE LIMITS\n"
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-8s  %-8s  %-9s  %-8s  %-14s  %-8s  %s\n",
+		"Metric", "Actual", "Projected", "Trend", "Method", "Limit", "Status")
+	output += strings.Repeat("-", 8+2+8+2+9+2+8+2+14+2+8+2+20) + "\n"
+	for _, f := range rc.forecasts {
+		limit := "-"
+		if f.Limit > 0 {
+			limit = fmt.Sprint(f.Limit)
+		}
+		method := f.Method
+		if method == "" {
+			method = "-"
 		}
+		output += fmt.Sprintf("%-8s  %-8d  %-9s  %-8s  %-14s  %-8s  %s\n",
+			f.Metric, f.Actual, formatOptional(f.Projected), formatOptional(f.Trend), method, limit, f.Status)
+	}
+	return output + "\n"
+}
 
-		for _, event := range rawEvents {
-			data, err := json.Marshal(event)
+// billedCycle is one cycle of a -reconcile file: the figures the billing
+// portal charged for the cycle starting on Start. A nil count was not
+// provided and is not compared.
+type billedCycle struct {
+	Start string `json:"cycle_start"`
+	ZTA   *int   `json:"zta_mau"`
+	IG    *int   `json:"ig_mau"`
+	MWI   *int   `json:"mwi_bots"`
+}
+
+// get returns the billed count of a metric, or nil if it was not provided.
+func (b billedCycle) get(metric string) *int {
+	switch metric {
+	case metricZTA:
+		return b.ZTA
+	case metricIG:
+		return b.IG
+	default:
+		return b.MWI
+	}
+}
+
+// start parses the cycle start, given as a date or an RFC 3339 time.
+func (b billedCycle) start() (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", b.Start, reportLocation); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, b.Start)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cycle_start %q (expected YYYY-MM-DD)", b.Start)
+	}
+	return dayStart(t), nil
+}
+
+// loadBilledCycles reads a -reconcile file. A .json file holds a list of
+// billedCycle objects, bare or under "cycles"; anything else is read as CSV
+// with a header naming the same columns. Other columns are ignored.
+func loadBilledCycles(path string) ([]billedCycle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var billed []billedCycle
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var wrapped struct {
+			Cycles []billedCycle `json:"cycles"`
+		}
+		if err := json.Unmarshal(data, &billed); err != nil {
+			if err := json.Unmarshal(data, &wrapped); err != nil {
+				return nil, err
+			}
+			billed = wrapped.Cycles
+		}
+	} else {
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("empty file")
+		}
+		column := make(map[string]int)
+		for i, name := range records[0] {
+			column[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := column["cycle_start"]; !ok {
+			return nil, fmt.Errorf("header has no cycle_start column")
+		}
+		field := func(record []string, name string) (*int, error) {
+			i, ok := column[name]
+			if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				return nil, nil
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(record[i]))
 			if err != nil {
-				log.Printf("Failed to marshal event: %v", err)
-				continue
+				return nil, fmt.Errorf("invalid %s %q", name, record[i])
+			}
+			return &n, nil
+		}
+		for line, record := range records[1:] {
+			b := billedCycle{Start: strings.TrimSpace(record[column["cycle_start"]])}
+			if b.ZTA, err = field(record, "zta_mau"); err == nil {
+				if b.IG, err = field(record, "ig_mau"); err == nil {
+					b.MWI, err = field(record, "mwi_bots")
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line+2, err)
 			}
+			billed = append(billed, b)
+		}
+	}
+
+	for _, b := range billed {
+		if _, err := b.start(); err != nil {
+			return nil, err
+		}
+	}
+	return billed, nil
+}
+
+// Reasons a user may be counted differently by this report and the billing
+// portal.
+const (
+	reasonHeuristic   = "classified as %s by heuristic (%s)"
+	reasonFirstDay    = "only active on the cycle's first day"
+	reasonLastDay     = "only active on the cycle's last day"
+	reasonDayBefore   = "active the day before the cycle, not during it"
+	reasonDayAfter    = "active the day after the cycle, not during it"
+	reasonUnexplained = "no candidate users found"
+)
+
+// reconcileUser is a user that may account for part of a difference.
+// Counted tells whether this report counts the user in the metric.
+type reconcileUser struct {
+	User    string `json:"user"`
+	Counted bool   `json:"counted"`
+	Reason  string `json:"reason"`
+}
+
+// reconcileRow compares one billed figure with the reported one. Difference
+// is billed minus reported; Users lists the candidates that could explain it.
+type reconcileRow struct {
+	Cycle      string          `json:"cycle"`
+	Metric     string          `json:"metric"`
+	Billed     int             `json:"billed"`
+	Reported   int             `json:"reported"`
+	Difference int             `json:"difference"`
+	Users      []reconcileUser `json:"users,omitempty"`
+}
+
+// billedAs reports whether the metric bills user, going by its final kind.
+func billedAs(classifications map[string]userClassification, user, metric string) bool {
+	bot := classifications[user].Kind == UserKindBot
+	return bot == (metric == metricMWI)
+}
+
+// metricUsers returns the users with activity in a metric's columns, bots
+// and humans alike: ZTA and IG by their own columns, MWI by either.
+func metricUsers(s cycleSummary, metric string) map[string]struct{} {
+	switch metric {
+	case metricZTA:
+		return setOf(s.ztaMAUAll)
+	case metricIG:
+		return setOf(s.igMAUAll)
+	default:
+		return s.activeUsers()
+	}
+}
+
+// setOf returns the keys of a string-keyed map as a set.
+func setOf[T any](m map[string]T) map[string]struct{} {
+	out := make(map[string]struct{}, len(m))
+	for k := range m {
+		out[k] = struct{}{}
+	}
+	return out
+}
+
+// reconcile lines the billed figures up with the reported cycles, matched by
+// start date, and lists the users that could explain each difference. When
+// the portal billed more, the candidates are users this report leaves out of
+// the metric; when it billed less, users this report counts. Cycles in the
+// file that are not in the report are skipped with a warning.
+func reconcile(billed []billedCycle, cycles []cycleBounds, summaries []cycleSummary, days dailyAccums, classifications map[string]userClassification) []reconcileRow {
+	daySummaries := make(map[time.Time]cycleSummary)
+	dayUsers := func(day time.Time, metric string) map[string]struct{} {
+		s, ok := daySummaries[day]
+		if !ok && days[day] != nil {
+			s = days[day].summarize()
+			daySummaries[day] = s
+		}
+		return metricUsers(s, metric)
+	}
 
-			var raw map[string]interface{}
-			if err := json.Unmarshal(data, &raw); err != nil {
-				log.Printf("Failed to unmarshal event data: %v", err)
+	var rows []reconcileRow
+	for _, b := range billed {
+		start, _ := b.start()
+		i := 0
+		for i < len(cycles) && !dayStart(cycles[i].Start).Equal(start) {
+			i++
+		}
+		if i == len(cycles) {
+			log.Printf("[WARN] -reconcile cycle starting %s is not in the report; skipped", start.Format("2006-01-02"))
+			continue
+		}
+		c := cycles[i]
+		first, last := dayStart(c.Start), dayStart(c.End).AddDate(0, 0, -1)
+
+		for _, metric := range []string{metricZTA, metricIG, metricMWI} {
+			n := b.get(metric)
+			if n == nil {
+				continue
+			}
+			row := reconcileRow{
+				Cycle:    cycleLabel(c),
+				Metric:   metric,
+				Billed:   *n,
+				Reported: summaries[i].counts().get(metric),
+			}
+			row.Difference = row.Billed - row.Reported
+			if row.Difference == 0 {
+				rows = append(rows, row)
 				continue
 			}
 
-			if billingDay > 0 {
-				et := event.GetTime().UTC()
-				idx := -1
-				for i, c := range cycles {
-					if !et.Before(c.Start) && et.Before(c.End) {
-						idx = i
-						break
+			active := metricUsers(summaries[i], metric)
+			counted := row.Difference < 0
+			var heuristic, boundary []reconcileUser
+			for _, user := range sortedKeys(active) {
+				if cl := classifications[user]; cl.Heuristic && billedAs(classifications, user, metric) == counted {
+					heuristic = append(heuristic, reconcileUser{user, counted, fmt.Sprintf(reasonHeuristic, cl.Kind, cl.Source)})
+				}
+			}
+			if counted {
+				// Counted users whose only active day a shifted cut-off
+				// would move into the neighbouring cycle.
+				seenOn := make(map[string][]time.Time)
+				for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+					for user := range dayUsers(day, metric) {
+						seenOn[user] = append(seenOn[user], day)
 					}
 				}
-				if idx < 0 {
-					continue
+				for _, user := range sortedKeys(active) {
+					seen := seenOn[user]
+					switch {
+					case !billedAs(classifications, user, metric) || len(seen) != 1:
+					case seen[0].Equal(first):
+						boundary = append(boundary, reconcileUser{user, true, reasonFirstDay})
+					case seen[0].Equal(last) && !c.InProgress:
+						boundary = append(boundary, reconcileUser{user, true, reasonLastDay})
+					}
 				}
-				accums[idx].ingest(raw)
 			} else {
-				single.ingest(raw)
+				// Uncounted users whose activity a shifted cut-off would
+				// pull into this cycle.
+				neighbour := func(day time.Time, reason string) {
+					for _, user := range sortedKeys(dayUsers(day, metric)) {
+						if _, ok := active[user]; !ok && billedAs(classifications, user, metric) {
+							boundary = append(boundary, reconcileUser{user, false, reason})
+						}
+					}
+				}
+				neighbour(first.AddDate(0, 0, -1), reasonDayBefore)
+				if !c.InProgress {
+					neighbour(last.AddDate(0, 0, 1), reasonDayAfter)
+				}
+			}
+			row.Users = append(heuristic, boundary...)
+			if len(row.Users) == 0 {
+				row.Users = []reconcileUser{{Reason: reasonUnexplained}}
 			}
+			rows = append(rows, row)
 		}
+	}
+	return rows
+}
 
-		// If no next page, break
-		if newNextKey == "" || newNextKey == nextKey {
-			break
+// formatReconcileTable renders the -reconcile section.
+func (rc *reportContext) formatReconcileTable() string {
+	cycleWidth, userWidth := len("Cycle"), len("User")
+	for _, row := range rc.reconciliation {
+		if len(row.Cycle) > cycleWidth {
+			cycleWidth = len(row.Cycle)
 		}
-		nextKey = newNextKey
+		for _, u := range row.Users {
+			if len(u.User) > userWidth {
+				userWidth = len(u.User)
+			}
+		}
+	}
+	cycleWidth += 2
+	userWidth += 2
+
+	output := fmt.Sprintf("RECONCILIATION WITH BILLED FIGURES (%s)\n", reconcilePath)
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-8s  %-8s  %-8s  %s\n", cycleWidth, "Cycle", "Metric", "Billed", "Reported", "Difference")
+	output += strings.Repeat("-", cycleWidth+2+8+2+8+2+8+2+10) + "\n"
+	for _, row := range rc.reconciliation {
+		output += fmt.Sprintf("%-*s  %-8s  %-8d  %-8d  %+d\n", cycleWidth, row.Cycle, row.Metric, row.Billed, row.Reported, row.Difference)
 	}
 
-	if billingDay > 0 {
-		summaries := make([]cycleSummary, len(cycles))
-		for i, a := range accums {
-			summaries[i] = a.summarize()
+	var explained bool
+	for _, row := range rc.reconciliation {
+		if len(row.Users) == 0 {
+			continue
 		}
-		writePerCycleReport(cycles, accums, summaries)
-	} else {
-		s := single.summarize()
-		writeUserReport(s.ztaMAUAll, s.igMAUAll, single.userKind, single.totalLogins, s.ztaHumanCount, s.igHumanCount, s.mwiBotCount)
+		if !explained {
+			output += "\nUsers that may explain the differences:\n"
+			output += fmt.Sprintf("%-*s  %-8s  %-*s  %-7s  %s\n", cycleWidth, "Cycle", "Metric", userWidth, "User", "Counted", "Reason")
+			output += strings.Repeat("-", cycleWidth+2+8+2+userWidth+2+7+2+40) + "\n"
+			explained = true
+		}
+		for _, u := range row.Users {
+			user, counted := u.User, "no"
+			if user == "" {
+				user, counted = "-", "-"
+			} else if u.Counted {
+				counted = "yes"
+			}
+			output += fmt.Sprintf("%-*s  %-8s  %-*s  %-7s  %s\n", cycleWidth, row.Cycle, row.Metric, userWidth, user, counted, u.Reason)
+		}
+	}
+	return output + "\n"
+}
+
+// reconcileCSVPath is where -reconcile writes its CSV next to a CSV report.
+func reconcileCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_reconcile.csv"
+}
+
+// writeReconcileCSV writes the -reconcile section as its own CSV file, one
+// row per candidate user (or one row per figure without candidates).
+func (rc *reportContext) writeReconcileCSV() {
+	file, err := os.OpenFile(reconcileCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open reconcile CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"cycle", "metric", "billed", "reported", "difference", "user", "counted", "reason"}); err != nil {
+		log.Fatalf("Failed to write reconcile CSV: %v", err)
+	}
+	for _, row := range rc.reconciliation {
+		users := row.Users
+		if len(users) == 0 {
+			users = []reconcileUser{{}}
+		}
+		for _, u := range users {
+			counted := ""
+			if u.User != "" {
+				counted = fmt.Sprint(u.Counted)
+			}
+			record := []string{row.Cycle, row.Metric, fmt.Sprint(row.Billed), fmt.Sprint(row.Reported),
+				fmt.Sprint(row.Difference), u.User, counted, u.Reason}
+			if err := w.Write(record); err != nil {
+				log.Fatalf("Failed to write reconcile CSV: %v", err)
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write reconcile CSV: %v", err)
+	}
+	log.Printf("[INFO] Reconciliation written to %s", reconcileCSVPath())
+}
+
+// reportPath returns the -output path, or the default filename for the format.
+func reportPath() string {
+	if outputPath != "" {
+		return outputPath
+	}
+	switch reportFormat {
+	case "json":
+		return outputFilenameJson
+	case "csv":
+		return outputFilenameCSV
+	case "html":
+		return outputFilenameHTML
+	default:
+		return outputFilenameText
 	}
 }
 
 // Writes the user activity report to a file in either JSON or text format
-func writeUserReport(
+func (rc *reportContext) writeUserReport(
 	ztaMAU map[string]*UserResourceUsage,
 	igMAU map[string]*UserIGUsage,
 	userKind map[string]UserKindLabel,
@@ -607,6 +4406,9 @@ func writeUserReport(
 	ztaHumanCount int,
 	igHumanCount int,
 	mwiBotCount int,
+	resources userResources,
+	signals userSignals,
+	sessions userSessions,
 ) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 
@@ -615,6 +4417,8 @@ func writeUserReport(
 		reportData := map[string]interface{}{
 			"teleport_proxy_url":      teleportProxyURL,
 			"timestamp":               timestamp,
+			"window":                  rc.window,
+			"timezone":                reportLocation.String(),
 			"total_ztamau_users":      ztaHumanCount,
 			"total_igmau_users":       igHumanCount,
 			"total_mwi_bots":          mwiBotCount,
@@ -623,6 +4427,40 @@ func writeUserReport(
 			"zta_resource_usage_all":  ztaMAU,
 			"ig_feature_usage_all":    igMAU,
 		}
+		if rc.userGroups != nil {
+			reportData["group_by"] = groupBy
+			reportData["groups"] = rc.groupTotals(cycleSummary{ztaMAUAll: ztaMAU, igMAUAll: igMAU}, userKind)
+		}
+		if rows := rc.heuristicClassifications(); len(rows) > 0 {
+			reportData["heuristic_classifications"] = rows
+		}
+		if detailMode {
+			reportData["resource_access"] = resourceAccess(resources)
+		}
+		if securityMode {
+			reportData["security"] = rc.securityRows(signals)
+		}
+		if sessionMode {
+			reportData["sessions"] = sessionRows(sessions)
+		}
+		if len(rc.forecasts) > 0 {
+			reportData["forecast"] = rc.forecasts
+		}
+		if rc.clusterTotals != nil {
+			reportData["dedupe_by"] = dedupeBy
+			reportData["clusters"] = rc.clusterTotals
+		}
+		if reconcilePath != "" {
+			reportData["reconciliation"] = rc.reconciliation
+		}
+		if seriesMode {
+			reportData["daily_series"] = rc.dailySeries
+			reportData["weekly_series"] = rc.weeklySeries
+		}
+		if dormantDays > 0 {
+			reportData["dormant_days"] = dormantDays
+			reportData["dormant_users"] = rc.dormantUsers
+		}
 
 		jsonData, err := json.MarshalIndent(reportData, "", "  ")
 		if err != nil {
@@ -630,7 +4468,7 @@ func writeUserReport(
 		}
 
 		// Write JSON report to file
-		jsonFile, err := os.OpenFile(outputFilenameJson, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		jsonFile, err := os.OpenFile(reportPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 		if err != nil {
 			log.Fatalf("Failed to open JSON report file: %v", err)
 		}
@@ -641,11 +4479,11 @@ func writeUserReport(
 			log.Fatalf("Failed to write JSON report: %v", err)
 		}
 
-		log.Printf("[INFO] JSON report successfully written to %s at %s", outputFilenameJson, timestamp)
+		log.Printf("[INFO] JSON report successfully written to %s at %s", reportPath(), timestamp)
 
 	} else {
 		// Default Text Output
-		file, err := os.OpenFile(outputFilenameText, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := os.OpenFile(reportPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			log.Fatalf("Failed to open report file: %v", err)
 		}
@@ -654,6 +4492,7 @@ func writeUserReport(
 		// Generate report header
 		output := fmt.Sprintf("\n[%s] Teleport Active Users Report\n", timestamp)
 		output += fmt.Sprintf("Teleport Proxy URL: %s\n", teleportProxyURL)
+		output += fmt.Sprintf("Window: %s (%s)\n", rc.window, reportLocation)
 		output += "=================================================\n"
 		output += fmt.Sprintf("Total Zero Trust Access MAU (ZTA MAU): %d\n", ztaHumanCount)
 		output += fmt.Sprintf("Total Identity Governance MAU (IG MAU): %d\n", igHumanCount)
@@ -661,14 +4500,36 @@ func writeUserReport(
 		output += fmt.Sprintf("Total Successful Logins: %d\n", totalLogins)
 		output += "=================================================\n\n"
 
+		output += rc.formatForecastTable()
+		if rc.clusterTotals != nil {
+			output += rc.formatClusterTable()
+		}
+		if rc.userGroups != nil {
+			output += formatGroupTable(rc.groupTotals(cycleSummary{ztaMAUAll: ztaMAU, igMAUAll: igMAU}, userKind))
+		}
 		output += formatUserTables(ztaMAU, igMAU, userKind)
+		if detailMode {
+			output += "\n" + formatResourceTable(resources)
+		}
+		if securityMode {
+			output += "\n" + rc.formatSecurityTable(signals)
+		}
+		if sessionMode {
+			output += "\n" + formatSessionTable(sessions)
+		}
+		if heuristics := rc.formatHeuristicTable(); heuristics != "" {
+			output += "\n" + heuristics
+		}
+		if dormantDays > 0 {
+			output += "\n" + rc.formatDormantTable()
+		}
 
 		_, err = file.WriteString(output)
 		if err != nil {
 			log.Fatalf("Failed to write to report file: %v", err)
 		}
 
-		log.Printf("[INFO] Text report successfully written to %s at %s", outputFilenameText, timestamp)
+		log.Printf("[INFO] Text report successfully written to %s at %s", reportPath(), timestamp)
 	}
 }
 
@@ -691,60 +4552,374 @@ func formatUserTables(
 			}
 		}
 
-		userColWidth := maxUserLen + 2
-		kindColWidth := 6
+		userColWidth := maxUserLen + 2
+		kindColWidth := 6
+
+		output += fmt.Sprintf("%-*s  %-*s  %-8s  %-8s  %-8s  %-8s  %-8s  %-8s\n",
+			userColWidth, "User",
+			kindColWidth, "Kind",
+			"Logins", "SSH", "Kube", "DB", "App", "Desktop")
+
+		separatorLen := userColWidth + 2 + kindColWidth + 2 + 8*6 + 2*6
+		output += fmt.Sprintf("%s\n", strings.Repeat("-", separatorLen))
+
+		for _, user := range sortedKeys(ztaMAU) {
+			usage := ztaMAU[user]
+			kind := userKind[user]
+			if kind == "" {
+				kind = UserKindHuman
+			}
+
+			output += fmt.Sprintf("%-*s  %-*s  %-8d  %-8d  %-8d  %-8d  %-8d  %-8d\n",
+				userColWidth, user,
+				kindColWidth, kind,
+				usage.LoginCount, usage.SSH, usage.Kubernetes,
+				usage.Database, usage.Application, usage.Desktop)
+		}
+		output += "\n"
+	}
+
+	if len(igMAU) > 0 {
+		output += "IDENTITY GOVERNANCE (IG MAU) - Feature Usage\n"
+		output += "-------------------------------------------------\n"
+
+		maxUserLen := 4
+		for user := range igMAU {
+			if len(user) > maxUserLen {
+				maxUserLen = len(user)
+			}
+		}
+
+		userColWidth := maxUserLen + 2
+		output += fmt.Sprintf("%-*s  %-12s  %-12s  %-12s  %-12s  %-12s\n",
+			userColWidth, "User", "Req Created", "Req Reviewed", "List Member", "List Review", "SAML IdP")
+
+		separatorLen := userColWidth + 2 + 12*5 + 2*5
+		output += fmt.Sprintf("%s\n", strings.Repeat("-", separatorLen))
+
+		for _, user := range sortedKeys(igMAU) {
+			usage := igMAU[user]
+			output += fmt.Sprintf("%-*s  %-12d  %-12d  %-12d  %-12d  %-12d\n",
+				userColWidth, user, usage.AccessRequestsCreated, usage.AccessRequestsReviewed,
+				usage.AccessListsMemberships, usage.AccessListsReviewed, usage.SAMLIDPSessions)
+		}
+	}
+
+	return output
+}
+
+// resourceAccess returns the -detail resources of every user, sorted, in the
+// shape used by the JSON reports.
+func resourceAccess(resources userResources) map[string][]*resourceSeen {
+	out := make(map[string][]*resourceSeen, len(resources))
+	for user := range resources {
+		out[user] = resources.sorted(user)
+	}
+	return out
+}
+
+// formatResourceTable renders the -detail resources of every user.
+func formatResourceTable(resources userResources) string {
+	if len(resources) == 0 {
+		return ""
+	}
+
+	userColWidth, nameColWidth, accountColWidth := 4, 8, 7
+	for _, user := range sortedKeys(resources) {
+		if len(user) > userColWidth {
+			userColWidth = len(user)
+		}
+		for _, r := range resources[user] {
+			if len(r.Name) > nameColWidth {
+				nameColWidth = len(r.Name)
+			}
+			if len(r.Account) > accountColWidth {
+				accountColWidth = len(r.Account)
+			}
+		}
+	}
+	userColWidth += 2
+	nameColWidth += 2
+	accountColWidth += 2
+
+	output := "RESOURCE ACCESS DETAIL\n"
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-11s  %-*s  %-*s  %-16s  %-16s  %s\n",
+		userColWidth, "User", "Kind", nameColWidth, "Resource", accountColWidth, "Account",
+		"First Seen", "Last Seen", "Count")
+	output += strings.Repeat("-", userColWidth+2+11+2+nameColWidth+2+accountColWidth+2+16+2+16+2+5) + "\n"
+	for _, user := range sortedKeys(resources) {
+		for _, r := range resources.sorted(user) {
+			output += fmt.Sprintf("%-*s  %-11s  %-*s  %-*s  %-16s  %-16s  %d\n",
+				userColWidth, user, r.Kind, nameColWidth, r.Name, accountColWidth, r.Account,
+				r.FirstSeen.In(reportLocation).Format("2006-01-02 15:04"),
+				r.LastSeen.In(reportLocation).Format("2006-01-02 15:04"), r.Count)
+		}
+	}
+	return output
+}
+
+// securityRow is one user's -security signals in a cycle.
+type securityRow struct {
+	User            string         `json:"user"`
+	Logins          int            `json:"successful_logins"`
+	FailedLogins    int            `json:"failed_logins"`
+	Methods         map[string]int `json:"login_methods,omitempty"`
+	MFADevices      map[string]int `json:"mfa_devices,omitempty"`
+	SessionsWithMFA int            `json:"sessions_with_mfa"`
+	TrustedDevice   int            `json:"trusted_device_events"`
+	UntrustedDevice int            `json:"untrusted_device_events"`
+	SSOConnector    string         `json:"sso_connector,omitempty"`
+}
+
+// securityRows turns a cycle's signals into one row per user, sorted by name.
+func (rc *reportContext) securityRows(signals userSignals) []securityRow {
+	rows := make([]securityRow, 0, len(signals))
+	for _, user := range sortedKeys(signals) {
+		s := signals[user]
+		rows = append(rows, securityRow{
+			User:            user,
+			Logins:          s[signalLogin]["success"],
+			FailedLogins:    s[signalLogin]["failed"],
+			Methods:         s[signalMethod],
+			MFADevices:      s[signalMFA],
+			SessionsWithMFA: s[signalSessionMFA][""],
+			TrustedDevice:   s[signalDevice]["trusted"],
+			UntrustedDevice: s[signalDevice]["untrusted"],
+			SSOConnector:    rc.ssoConnectors[user],
+		})
+	}
+	return rows
+}
+
+// lookupSSOConnectors returns the SSO connector that created each user,
+// keyed like the report (by identity when several clusters are combined).
+func lookupSSOConnectors(runs []clusterRun) map[string]string {
+	connectors := make(map[string][]string)
+	for i := range runs {
+		r := &runs[i]
+		for _, u := range r.Users {
+			c := u.GetCreatedBy().Connector
+			if c == nil || c.ID == "" {
+				continue
+			}
+			key := r.identity(u.GetName())
+			connectors[key] = mergeSorted(connectors[key], []string{c.Type + ":" + c.ID})
+		}
+	}
+	out := make(map[string]string, len(connectors))
+	for user, names := range connectors {
+		out[user] = strings.Join(names, ", ")
+	}
+	return out
+}
+
+// formatCounts renders a value -> count map as "a=2, b=1", sorted by value.
+func formatCounts(counts map[string]int, sep string) string {
+	parts := make([]string, 0, len(counts))
+	for _, k := range sortedKeys(counts) {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(parts, sep)
+}
+
+// formatSecurityTable renders the -security section for one cycle.
+func (rc *reportContext) formatSecurityTable(signals userSignals) string {
+	rows := rc.securityRows(signals)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	userColWidth, methodColWidth, mfaColWidth := 4, 7, 10
+	for _, r := range rows {
+		if len(r.User) > userColWidth {
+			userColWidth = len(r.User)
+		}
+		if l := len(formatCounts(r.Methods, ", ")); l > methodColWidth {
+			methodColWidth = l
+		}
+		if l := len(formatCounts(r.MFADevices, ", ")); l > mfaColWidth {
+			mfaColWidth = l
+		}
+	}
+	userColWidth += 2
+	methodColWidth += 2
+	mfaColWidth += 2
+
+	output := "SECURITY SIGNALS (not part of the MAU counts)\n"
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-6s  %-6s  %-*s  %-*s  %-8s  %-8s  %-9s  %s\n",
+		userColWidth, "User", "Logins", "Failed", methodColWidth, "Methods", mfaColWidth, "MFA Device",
+		"Sess MFA", "Trusted", "Untrusted", "SSO Connector")
+	output += strings.Repeat("-", userColWidth+2+6+2+6+2+methodColWidth+2+mfaColWidth+2+8+2+8+2+9+2+13) + "\n"
+	for _, r := range rows {
+		output += fmt.Sprintf("%-*s  %-6d  %-6d  %-*s  %-*s  %-8d  %-8d  %-9d  %s\n",
+			userColWidth, r.User, r.Logins, r.FailedLogins,
+			methodColWidth, formatCounts(r.Methods, ", "), mfaColWidth, formatCounts(r.MFADevices, ", "),
+			r.SessionsWithMFA, r.TrustedDevice, r.UntrustedDevice, r.SSOConnector)
+	}
+	return output
+}
+
+// securityCSVPath is where -security writes its CSV next to a CSV report.
+func securityCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_security.csv"
+}
+
+// writeSecurityCSV writes the -security section as its own CSV file, one
+// row per user and cycle.
+func (rc *reportContext) writeSecurityCSV(cycles []cycleBounds, accums []*cycleAccum) {
+	file, err := os.OpenFile(securityCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open security CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	header := []string{
+		"cycle", "user", "successful_logins", "failed_logins", "login_methods", "mfa_devices",
+		"sessions_with_mfa", "trusted_device_events", "untrusted_device_events", "sso_connector",
+	}
+	if err := w.Write(header); err != nil {
+		log.Fatalf("Failed to write security CSV: %v", err)
+	}
+	for i, c := range cycles {
+		for _, r := range rc.securityRows(accums[i].signals) {
+			row := []string{
+				c.Label, r.User, fmt.Sprint(r.Logins), fmt.Sprint(r.FailedLogins),
+				formatCounts(r.Methods, ";"), formatCounts(r.MFADevices, ";"),
+				fmt.Sprint(r.SessionsWithMFA), fmt.Sprint(r.TrustedDevice), fmt.Sprint(r.UntrustedDevice),
+				r.SSOConnector,
+			}
+			if err := w.Write(row); err != nil {
+				log.Fatalf("Failed to write security CSV: %v", err)
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write security CSV: %v", err)
+	}
+	log.Printf("[INFO] Security signals written to %s", securityCSVPath())
+}
 
-		output += fmt.Sprintf("%-*s  %-*s  %-8s  %-8s  %-8s  %-8s  %-8s  %-8s\n",
-			userColWidth, "User",
-			kindColWidth, "Kind",
-			"Logins", "SSH", "Kube", "DB", "App", "Desktop")
+// sessionRow is one user's -sessions usage of one category in a cycle.
+type sessionRow struct {
+	User             string `json:"user"`
+	Category         string `json:"category"`
+	Sessions         int    `json:"sessions"`
+	Open             int    `json:"open_sessions"`
+	ConnectedSeconds int64  `json:"connected_seconds"`
+}
 
-		separatorLen := userColWidth + 2 + kindColWidth + 2 + 8*6 + 2*6
-		output += fmt.Sprintf("%s\n", strings.Repeat("-", separatorLen))
+// Connected renders the row's connected time.
+func (r sessionRow) Connected() string {
+	return formatDuration(time.Duration(r.ConnectedSeconds) * time.Second)
+}
 
-		for _, user := range sortedKeys(ztaMAU) {
-			usage := ztaMAU[user]
-			kind := userKind[user]
-			if kind == "" {
-				kind = UserKindHuman
-			}
+// Average renders the mean connected time per session, or "-" when no
+// session started in the cycle.
+func (r sessionRow) Average() string {
+	if r.Sessions == 0 {
+		return "-"
+	}
+	return formatDuration(time.Duration(r.ConnectedSeconds) * time.Second / time.Duration(r.Sessions))
+}
 
-			output += fmt.Sprintf("%-*s  %-*s  %-8d  %-8d  %-8d  %-8d  %-8d  %-8d\n",
-				userColWidth, user,
-				kindColWidth, kind,
-				usage.LoginCount, usage.SSH, usage.Kubernetes,
-				usage.Database, usage.Application, usage.Desktop)
+// sessionRows turns a cycle's connected time into one row per user and
+// category, sorted by user then category.
+func sessionRows(sessions userSessions) []sessionRow {
+	rows := make([]sessionRow, 0, len(sessions))
+	for _, user := range sortedKeys(sessions) {
+		for _, category := range sortedKeys(sessions[user]) {
+			u := sessions[user][category]
+			rows = append(rows, sessionRow{
+				User:             user,
+				Category:         category,
+				Sessions:         u.Sessions,
+				Open:             u.Open,
+				ConnectedSeconds: int64(u.Connected / time.Second),
+			})
 		}
-		output += "\n"
 	}
+	return rows
+}
 
-	if len(igMAU) > 0 {
-		output += "IDENTITY GOVERNANCE (IG MAU) - Feature Usage\n"
-		output += "-------------------------------------------------\n"
+// formatDuration renders d as hours and minutes, e.g. "26h05m".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
 
-		maxUserLen := 4
-		for user := range igMAU {
-			if len(user) > maxUserLen {
-				maxUserLen = len(user)
-			}
+// formatSessionTable renders the -sessions section for one cycle.
+func formatSessionTable(sessions userSessions) string {
+	rows := sessionRows(sessions)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	userColWidth, categoryColWidth := 4, 8
+	for _, r := range rows {
+		if len(r.User) > userColWidth {
+			userColWidth = len(r.User)
 		}
+		if len(r.Category) > categoryColWidth {
+			categoryColWidth = len(r.Category)
+		}
+	}
+	userColWidth += 2
+	categoryColWidth += 2
 
-		userColWidth := maxUserLen + 2
-		output += fmt.Sprintf("%-*s  %-12s  %-12s  %-12s  %-12s  %-12s\n",
-			userColWidth, "User", "Req Created", "Req Reviewed", "List Member", "List Review", "SAML IdP")
+	output := "CONNECTED TIME BY USER (not part of the MAU counts)\n"
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-*s  %-8s  %-4s  %-10s  %s\n",
+		userColWidth, "User", categoryColWidth, "Category", "Sessions", "Open", "Connected", "Average")
+	output += strings.Repeat("-", userColWidth+2+categoryColWidth+2+8+2+4+2+10+2+7) + "\n"
+	for _, r := range rows {
+		output += fmt.Sprintf("%-*s  %-*s  %-8d  %-4d  %-10s  %s\n",
+			userColWidth, r.User, categoryColWidth, r.Category, r.Sessions, r.Open, r.Connected(), r.Average())
+	}
+	return output
+}
 
-		separatorLen := userColWidth + 2 + 12*5 + 2*5
-		output += fmt.Sprintf("%s\n", strings.Repeat("-", separatorLen))
+// sessionCSVPath is where -sessions writes its CSV next to a CSV report.
+func sessionCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_sessions.csv"
+}
 
-		for _, user := range sortedKeys(igMAU) {
-			usage := igMAU[user]
-			output += fmt.Sprintf("%-*s  %-12d  %-12d  %-12d  %-12d  %-12d\n",
-				userColWidth, user, usage.AccessRequestsCreated, usage.AccessRequestsReviewed,
-				usage.AccessListsMemberships, usage.AccessListsReviewed, usage.SAMLIDPSessions)
+// writeSessionCSV writes the -sessions section as its own CSV file, one row
+// per user, category and cycle.
+func writeSessionCSV(cycles []cycleBounds, accums []*cycleAccum) {
+	file, err := os.OpenFile(sessionCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open session CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	header := []string{"cycle", "user", "category", "sessions", "open_sessions", "connected_seconds"}
+	if err := w.Write(header); err != nil {
+		log.Fatalf("Failed to write session CSV: %v", err)
+	}
+	for i, c := range cycles {
+		for _, r := range sessionRows(accums[i].connected) {
+			row := []string{
+				c.Label, r.User, r.Category, fmt.Sprint(r.Sessions), fmt.Sprint(r.Open), fmt.Sprint(r.ConnectedSeconds),
+			}
+			if err := w.Write(row); err != nil {
+				log.Fatalf("Failed to write session CSV: %v", err)
+			}
 		}
 	}
 
-	return output
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write session CSV: %v", err)
+	}
+	log.Printf("[INFO] Connected time written to %s", sessionCSVPath())
 }
 
 // cycleLabel returns the human-readable cycle label, suffixed when in progress.
@@ -755,8 +4930,9 @@ func cycleLabel(c cycleBounds) string {
 	return c.Label
 }
 
-// writePerCycleReport emits a billing-cycle-aligned report (text or JSON).
-func writePerCycleReport(cycles []cycleBounds, accums []*cycleAccum, summaries []cycleSummary) {
+// writePerCycleReport emits a report per billing cycle, month or week (text
+// or JSON).
+func (rc *reportContext) writePerCycleReport(cycles []cycleBounds, accums []*cycleAccum, summaries []cycleSummary) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 
 	if reportFormat == "json" {
@@ -776,21 +4952,61 @@ func writePerCycleReport(cycles []cycleBounds, accums []*cycleAccum, summaries [
 				"zta_resource_usage_all":  s.ztaMAUAll,
 				"ig_feature_usage_all":    s.igMAUAll,
 			}
+			if rc.userGroups != nil {
+				cycleData[i]["groups"] = rc.groupTotals(s, accums[i].userKind)
+			}
+			if detailMode {
+				cycleData[i]["resource_access"] = resourceAccess(accums[i].resources)
+			}
+			if securityMode {
+				cycleData[i]["security"] = rc.securityRows(accums[i].signals)
+			}
+			if sessionMode {
+				cycleData[i]["sessions"] = sessionRows(accums[i].connected)
+			}
 		}
 
 		reportData := map[string]interface{}{
 			"teleport_proxy_url": teleportProxyURL,
 			"timestamp":          timestamp,
 			"billing_anchor_day": billingDayAnchor,
+			"timezone":           reportLocation.String(),
 			"cycles":             cycleData,
 		}
+		if billingDayAnchor == 0 {
+			reportData["window"] = windowMode
+		}
+		if rc.userGroups != nil {
+			reportData["group_by"] = groupBy
+		}
+		if rows := rc.heuristicClassifications(); len(rows) > 0 {
+			reportData["heuristic_classifications"] = rows
+		}
+		if len(rc.forecasts) > 0 {
+			reportData["forecast"] = rc.forecasts
+		}
+		if rc.clusterTotals != nil {
+			reportData["dedupe_by"] = dedupeBy
+			reportData["clusters"] = rc.clusterTotals
+		}
+		if reconcilePath != "" {
+			reportData["reconciliation"] = rc.reconciliation
+		}
+		if seriesMode {
+			reportData["daily_series"] = rc.dailySeries
+			reportData["weekly_series"] = rc.weeklySeries
+		}
+		if dormantDays > 0 {
+			reportData["dormant_days"] = dormantDays
+			reportData["dormant_users"] = rc.dormantUsers
+		}
 
 		jsonData, err := json.MarshalIndent(reportData, "", "  ")
 		if err != nil {
 			log.Fatalf("Failed to generate JSON report: %v", err)
 		}
 
-		jsonFile, err := os.OpenFile(outputFilenameJson, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		jsonFile, err := os.OpenFile(reportPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 		if err != nil {
 			log.Fatalf("Failed to open JSON report file: %v", err)
 		}
@@ -799,20 +5015,25 @@ func writePerCycleReport(cycles []cycleBounds, accums []*cycleAccum, summaries [
 		if _, err := jsonFile.Write(jsonData); err != nil {
 			log.Fatalf("Failed to write JSON report: %v", err)
 		}
-		log.Printf("[INFO] JSON report successfully written to %s at %s", outputFilenameJson, timestamp)
+		log.Printf("[INFO] JSON report successfully written to %s at %s", reportPath(), timestamp)
 		return
 	}
 
 	// Text output
-	file, err := os.OpenFile(outputFilenameText, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	file, err := os.OpenFile(reportPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		log.Fatalf("Failed to open report file: %v", err)
 	}
 	defer file.Close()
 
-	output := fmt.Sprintf("\n[%s] Teleport Active Users Report (billing cycles)\n", timestamp)
+	output := fmt.Sprintf("\n[%s] Teleport Active Users Report (%s)\n", timestamp, periodName())
 	output += fmt.Sprintf("Teleport Proxy URL: %s\n", teleportProxyURL)
-	output += fmt.Sprintf("Billing anchor day: %d\n", billingDayAnchor)
+	if billingDayAnchor > 0 {
+		output += fmt.Sprintf("Billing anchor day: %d\n", billingDayAnchor)
+	}
+	if reportLocation != time.UTC {
+		output += fmt.Sprintf("Timezone: %s\n", reportLocation)
+	}
 	output += "=================================================\n"
 
 	// Per-cycle summary table.
@@ -833,25 +5054,680 @@ func writePerCycleReport(cycles []cycleBounds, accums []*cycleAccum, summaries [
 			s.ztaHumanCount, s.igHumanCount, s.mwiBotCount, accums[i].totalLogins)
 	}
 	output += "=================================================\n\n"
+	output += rc.formatForecastTable()
+	if rc.clusterTotals != nil {
+		output += rc.formatClusterTable()
+	}
+	if reconcilePath != "" {
+		output += rc.formatReconcileTable()
+	}
 
 	// Per-cycle detail tables.
 	for i, c := range cycles {
 		s := summaries[i]
 		output += fmt.Sprintf("--- %s ---\n", cycleLabel(c))
+		if rc.userGroups != nil {
+			output += formatGroupTable(rc.groupTotals(s, accums[i].userKind))
+		}
 		tables := formatUserTables(s.ztaMAUAll, s.igMAUAll, accums[i].userKind)
 		if tables == "" {
 			output += "(no activity in this cycle)\n\n"
 		} else {
 			output += tables + "\n"
 		}
+		if detailMode && len(accums[i].resources) > 0 {
+			output += formatResourceTable(accums[i].resources) + "\n"
+		}
+		if securityMode && len(accums[i].signals) > 0 {
+			output += rc.formatSecurityTable(accums[i].signals) + "\n"
+		}
+		if sessionMode && len(accums[i].connected) > 0 {
+			output += formatSessionTable(accums[i].connected) + "\n"
+		}
+	}
+	output += rc.formatHeuristicTable()
+	if dormantDays > 0 {
+		output += rc.formatDormantTable()
 	}
 
 	if _, err = file.WriteString(output); err != nil {
 		log.Fatalf("Failed to write to report file: %v", err)
 	}
-	log.Printf("[INFO] Text report successfully written to %s at %s", outputFilenameText, timestamp)
+	log.Printf("[INFO] Text report successfully written to %s at %s", reportPath(), timestamp)
+}
+
+// csvHeader is the column layout of the CSV report: one row per user and
+// cycle, combining the ZTA and IG tables from formatUserTables.
+var csvHeader = []string{
+	"cycle", "cycle_start", "cycle_end", "in_progress", "user", "kind", "kind_source", "zta_active", "ig_active",
+	"login_count", "ssh", "kubernetes", "database", "application", "desktop",
+	"access_requests_created", "access_requests_reviewed", "access_lists_memberships",
+	"access_lists_reviewed", "saml_idp_sessions",
+}
+
+// writeCSVReport writes the per-user ZTA/IG usage of every cycle as CSV.
+func (rc *reportContext) writeCSVReport(cycles []cycleBounds, accums []*cycleAccum, summaries []cycleSummary) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+
+	file, err := os.OpenFile(reportPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open CSV report file: %v", err)
+	}
+	defer file.Close()
+
+	header := csvHeader
+	if rc.userGroups != nil {
+		header = append(append([]string{}, csvHeader...), "groups")
+	}
+
+	w := csv.NewWriter(file)
+	if err := w.Write(header); err != nil {
+		log.Fatalf("Failed to write CSV report: %v", err)
+	}
+
+	for i, c := range cycles {
+		s := summaries[i]
+		users := make(map[string]struct{})
+		for user := range s.ztaMAUAll {
+			users[user] = struct{}{}
+		}
+		for user := range s.igMAUAll {
+			users[user] = struct{}{}
+		}
+
+		for _, user := range sortedKeys(users) {
+			zta, ztaActive := s.ztaMAUAll[user]
+			if zta == nil {
+				zta = &UserResourceUsage{}
+			}
+			ig, igActive := s.igMAUAll[user]
+			if ig == nil {
+				ig = &UserIGUsage{}
+			}
+			kind := accums[i].userKind[user]
+			if kind == "" {
+				kind = UserKindHuman
+			}
+
+			row := []string{
+				c.Label, c.Start.Format(time.RFC3339), c.End.Format(time.RFC3339), fmt.Sprint(c.InProgress),
+				user, string(kind), rc.classifications[user].Source, fmt.Sprint(ztaActive), fmt.Sprint(igActive),
+				fmt.Sprint(zta.LoginCount), fmt.Sprint(zta.SSH), fmt.Sprint(zta.Kubernetes),
+				fmt.Sprint(zta.Database), fmt.Sprint(zta.Application), fmt.Sprint(zta.Desktop),
+				fmt.Sprint(ig.AccessRequestsCreated), fmt.Sprint(ig.AccessRequestsReviewed),
+				fmt.Sprint(ig.AccessListsMemberships), fmt.Sprint(ig.AccessListsReviewed),
+				fmt.Sprint(ig.SAMLIDPSessions),
+			}
+			if rc.userGroups != nil {
+				row = append(row, strings.Join(rc.userGroups[user], ";"))
+			}
+			if err := w.Write(row); err != nil {
+				log.Fatalf("Failed to write CSV report: %v", err)
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write CSV report: %v", err)
+	}
+	log.Printf("[INFO] CSV report successfully written to %s at %s", reportPath(), timestamp)
+
+	if detailMode {
+		writeResourceCSV(cycles, accums)
+	}
+	if securityMode {
+		rc.writeSecurityCSV(cycles, accums)
+	}
+	if sessionMode {
+		writeSessionCSV(cycles, accums)
+	}
+}
+
+// resourceCSVPath is where -detail writes the resource CSV next to the report.
+func resourceCSVPath() string {
+	path := reportPath()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_resources.csv"
+}
+
+// writeResourceCSV writes the -detail resource section as its own CSV file,
+// one row per user, resource and cycle.
+func writeResourceCSV(cycles []cycleBounds, accums []*cycleAccum) {
+	file, err := os.OpenFile(resourceCSVPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open resource CSV file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	header := []string{"cycle", "user", "kind", "resource", "account", "first_seen", "last_seen", "count"}
+	if err := w.Write(header); err != nil {
+		log.Fatalf("Failed to write resource CSV: %v", err)
+	}
+	for i, c := range cycles {
+		resources := accums[i].resources
+		for _, user := range sortedKeys(resources) {
+			for _, r := range resources.sorted(user) {
+				row := []string{
+					c.Label, user, r.Kind, r.Name, r.Account,
+					r.FirstSeen.In(reportLocation).Format(time.RFC3339),
+					r.LastSeen.In(reportLocation).Format(time.RFC3339), fmt.Sprint(r.Count),
+				}
+				if err := w.Write(row); err != nil {
+					log.Fatalf("Failed to write resource CSV: %v", err)
+				}
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write resource CSV: %v", err)
+	}
+	log.Printf("[INFO] Resource detail written to %s", resourceCSVPath())
+}
+
+// htmlBar is one bar of the per-cycle chart, pre-scaled to the SVG canvas.
+type htmlBar struct {
+	X, Y, Height int
+	Value        int
+	Class        string
+}
+
+// htmlCycle is the view of one cycle handed to htmlReportTemplate.
+type htmlCycle struct {
+	Label       string
+	ZTA, IG     int
+	MWI, Logins int
+	LabelX      int
+	Bars        []htmlBar
+	Groups      []groupRow
+	ZTAUsers    []htmlZTARow
+	IGUsers     []htmlIGRow
+	Security    []securityRow
+	Sessions    []sessionRow
+}
+
+type htmlZTARow struct {
+	User string
+	Kind UserKindLabel
+	*UserResourceUsage
+}
+
+type htmlIGRow struct {
+	User string
+	*UserIGUsage
+}
+
+// Dimensions of the SVG chart in the HTML report.
+const (
+	htmlChartHeight = 200
+	htmlBarWidth    = 24
+	htmlGroupWidth  = 4*htmlBarWidth + 40
+)
+
+// writeHTMLReport writes a self-contained HTML page with a bar chart of the
+// per-cycle totals followed by the per-user tables of every cycle.
+func (rc *reportContext) writeHTMLReport(cycles []cycleBounds, accums []*cycleAccum, summaries []cycleSummary) {
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+
+	maxValue := 1
+	for _, s := range summaries {
+		for _, v := range []int{s.ztaHumanCount, s.igHumanCount, s.mwiBotCount} {
+			if v > maxValue {
+				maxValue = v
+			}
+		}
+	}
+
+	view := make([]htmlCycle, len(cycles))
+	for i, c := range cycles {
+		s := summaries[i]
+		hc := htmlCycle{
+			Label:  cycleLabel(c),
+			ZTA:    s.ztaHumanCount,
+			IG:     s.igHumanCount,
+			MWI:    s.mwiBotCount,
+			Logins: accums[i].totalLogins,
+			LabelX: i*htmlGroupWidth + 20 + 3*htmlBarWidth/2,
+		}
+		for j, bar := range []struct {
+			value int
+			class string
+		}{{s.ztaHumanCount, "zta"}, {s.igHumanCount, "ig"}, {s.mwiBotCount, "mwi"}} {
+			height := bar.value * htmlChartHeight / maxValue
+			hc.Bars = append(hc.Bars, htmlBar{
+				X:      i*htmlGroupWidth + 20 + j*htmlBarWidth,
+				Y:      htmlChartHeight - height + 20,
+				Height: height,
+				Value:  bar.value,
+				Class:  bar.class,
+			})
+		}
+		if rc.userGroups != nil {
+			hc.Groups = rc.groupTotals(s, accums[i].userKind)
+		}
+		if securityMode {
+			hc.Security = rc.securityRows(accums[i].signals)
+		}
+		if sessionMode {
+			hc.Sessions = sessionRows(accums[i].connected)
+		}
+		for _, user := range sortedKeys(s.ztaMAUAll) {
+			kind := accums[i].userKind[user]
+			if kind == "" {
+				kind = UserKindHuman
+			}
+			hc.ZTAUsers = append(hc.ZTAUsers, htmlZTARow{User: user, Kind: kind, UserResourceUsage: s.ztaMAUAll[user]})
+		}
+		for _, user := range sortedKeys(s.igMAUAll) {
+			hc.IGUsers = append(hc.IGUsers, htmlIGRow{User: user, UserIGUsage: s.igMAUAll[user]})
+		}
+		view[i] = hc
+	}
+
+	file, err := os.OpenFile(reportPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open HTML report file: %v", err)
+	}
+	defer file.Close()
+
+	err = htmlReportTemplate.Execute(file, map[string]interface{}{
+		"ProxyURL":    teleportProxyURL,
+		"Timestamp":   timestamp,
+		"BillingDay":  billingDayAnchor,
+		"Timezone":    reportLocation.String(),
+		"GroupLabel":  groupLabel(),
+		"Heuristics":  rc.heuristicClassifications(),
+		"Forecasts":   rc.forecasts,
+		"Clusters":    rc.clusterTotals,
+		"DedupeBy":    dedupeBy,
+		"Reconcile":   rc.reconciliation,
+		"DormantDays": dormantDays,
+		"Dormant":     rc.dormantUsers,
+		"Cycles":      view,
+		"ChartWidth":  len(cycles)*htmlGroupWidth + 20,
+		"ChartHeight": htmlChartHeight + 60,
+	})
+	if err != nil {
+		log.Fatalf("Failed to write HTML report: %v", err)
+	}
+	log.Printf("[INFO] HTML report successfully written to %s at %s", reportPath(), timestamp)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Teleport Active Users Report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+h3 { font-size: 1em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #f4f4f4; }
+.zta { fill: #512fc9; }
+.ig { fill: #00bfa6; }
+.mwi { fill: #f5a623; }
+.legend span { display: inline-block; width: 12px; height: 12px; margin: 0 4px 0 12px; vertical-align: middle; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<h1>Teleport Active Users Report</h1>
+<p class="meta">Proxy: {{.ProxyURL}} &middot; Generated: {{.Timestamp}}{{if .BillingDay}} &middot; Billing anchor day: {{.BillingDay}}{{end}} &middot; Timezone: {{.Timezone}}</p>
+
+<h2>Summary</h2>
+<svg width="{{.ChartWidth}}" height="{{.ChartHeight}}" role="img" aria-label="MAU per cycle">
+{{- range .Cycles}}
+{{- range .Bars}}
+<rect class="{{.Class}}" x="{{.X}}" y="{{.Y}}" width="22" height="{{.Height}}"><title>{{.Value}}</title></rect>
+<text x="{{.X}}" y="{{.Y}}" dy="-4" font-size="10">{{.Value}}</text>
+{{- end}}
+<text x="{{.LabelX}}" y="{{$.ChartHeight}}" dy="-16" font-size="11" text-anchor="middle">{{.Label}}</text>
+{{- end}}
+</svg>
+<p class="legend"><span style="background:#512fc9"></span>ZTA MAU<span style="background:#00bfa6"></span>IG MAU<span style="background:#f5a623"></span>MWI bots</p>
+
+<table>
+<tr><th>Cycle</th><th>ZTA MAU</th><th>IG MAU</th><th>MWI</th><th>Logins</th></tr>
+{{- range .Cycles}}
+<tr><td>{{.Label}}</td><td>{{.ZTA}}</td><td>{{.IG}}</td><td>{{.MWI}}</td><td>{{.Logins}}</td></tr>
+{{- end}}
+</table>
+
+{{- if .Forecasts}}
+<h2>Forecast and Licence Limits</h2>
+<table>
+<tr><th>Metric</th><th>Actual</th><th>Projected</th><th>Trend</th><th>Method</th><th>Limit</th><th>Status</th></tr>
+{{- range .Forecasts}}
+<tr><td>{{.Metric}}</td><td>{{.Actual}}</td><td>{{if .Projected}}{{.Projected}}{{else}}-{{end}}</td><td>{{if .Trend}}{{.Trend}}{{else}}-{{end}}</td><td>{{or .Method "-"}}</td><td>{{if .Limit}}{{.Limit}}{{else}}-{{end}}</td><td>{{.Status}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Clusters}}
+<h2>MAU by Cluster (identities deduplicated by {{.DedupeBy}})</h2>
+<table>
+<tr><th>Cycle</th><th>Cluster</th><th>ZTA MAU</th><th>IG MAU</th><th>MWI</th><th>Logins</th></tr>
+{{- range .Clusters}}
+<tr><td>{{.Cycle}}</td><td>{{.Cluster}}</td><td>{{.ZTA}}</td><td>{{.IG}}</td><td>{{.MWI}}</td><td>{{.Logins}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Reconcile}}
+<h2>Reconciliation with Billed Figures</h2>
+<table>
+<tr><th>Cycle</th><th>Metric</th><th>Billed</th><th>Reported</th><th>Difference</th><th>Candidate Users</th></tr>
+{{- range .Reconcile}}
+<tr><td>{{.Cycle}}</td><td>{{.Metric}}</td><td>{{.Billed}}</td><td>{{.Reported}}</td><td>{{.Difference}}</td><td>{{range $i, $u := .Users}}{{if $i}}<br>{{end}}{{if $u.User}}{{$u.User}} ({{if $u.Counted}}counted{{else}}not counted{{end}}): {{end}}{{$u.Reason}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+{{- range .Cycles}}
+<h2>{{.Label}}</h2>
+{{- if and (not .ZTAUsers) (not .IGUsers)}}
+<p>(no activity in this cycle)</p>
+{{- end}}
+{{- if .Groups}}
+<h3>MAU by {{$.GroupLabel}}</h3>
+<table>
+<tr><th>{{$.GroupLabel}}</th><th>ZTA MAU</th><th>IG MAU</th><th>MWI</th></tr>
+{{- range .Groups}}
+<tr><td>{{.Group}}</td><td>{{.ZTA}}</td><td>{{.IG}}</td><td>{{.MWI}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .ZTAUsers}}
+<h3>Zero Trust Access (ZTA MAU) - Resource Usage</h3>
+<table>
+<tr><th>User</th><th>Kind</th><th>Logins</th><th>SSH</th><th>Kube</th><th>DB</th><th>App</th><th>Desktop</th></tr>
+{{- range .ZTAUsers}}
+<tr><td>{{.User}}</td><td>{{.Kind}}</td><td>{{.LoginCount}}</td><td>{{.SSH}}</td><td>{{.Kubernetes}}</td><td>{{.Database}}</td><td>{{.Application}}</td><td>{{.Desktop}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .IGUsers}}
+<h3>Identity Governance (IG MAU) - Feature Usage</h3>
+<table>
+<tr><th>User</th><th>Req Created</th><th>Req Reviewed</th><th>List Member</th><th>List Review</th><th>SAML IdP</th></tr>
+{{- range .IGUsers}}
+<tr><td>{{.User}}</td><td>{{.AccessRequestsCreated}}</td><td>{{.AccessRequestsReviewed}}</td><td>{{.AccessListsMemberships}}</td><td>{{.AccessListsReviewed}}</td><td>{{.SAMLIDPSessions}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Security}}
+<h3>Security Signals (not part of the MAU counts)</h3>
+<table>
+<tr><th>User</th><th>Logins</th><th>Failed</th><th>Methods</th><th>MFA Device</th><th>Sessions with MFA</th><th>Trusted Device</th><th>Untrusted Device</th><th>SSO Connector</th></tr>
+{{- range .Security}}
+<tr><td>{{.User}}</td><td>{{.Logins}}</td><td>{{.FailedLogins}}</td><td>{{range $k, $v := .Methods}}{{$k}}={{$v}} {{end}}</td><td>{{range $k, $v := .MFADevices}}{{$k}}={{$v}} {{end}}</td><td>{{.SessionsWithMFA}}</td><td>{{.TrustedDevice}}</td><td>{{.UntrustedDevice}}</td><td>{{or .SSOConnector "-"}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Sessions}}
+<h3>Connected Time by User (not part of the MAU counts)</h3>
+<table>
+<tr><th>User</th><th>Category</th><th>Sessions</th><th>Open</th><th>Connected</th><th>Average</th></tr>
+{{- range .Sessions}}
+<tr><td>{{.User}}</td><td>{{.Category}}</td><td>{{.Sessions}}</td><td>{{.Open}}</td><td>{{.Connected}}</td><td>{{.Average}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+{{- if .DormantDays}}
+<h2>Dormant Users (no ZTA/IG activity in {{.DormantDays}} days)</h2>
+{{- if .Dormant}}
+<table>
+<tr><th>User</th><th>Kind</th><th>Last Seen</th><th>Created</th><th>Roles</th></tr>
+{{- range .Dormant}}
+<tr><td>{{.User}}</td><td>{{.Kind}}</td><td>{{or .LastSeen "not in scanned window"}}</td><td>{{or .Created "-"}}</td><td>{{range $i, $r := .Roles}}{{if $i}}, {{end}}{{$r}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>(none)</p>
+{{- end}}
+{{- end}}
+{{- if .Heuristics}}
+<h2>Users Classified by Heuristic</h2>
+<p>The cluster and the audit events did not say whether these users are humans or bots. Verify them.</p>
+<table>
+<tr><th>User</th><th>Kind</th><th>Reason</th></tr>
+{{- range .Heuristics}}
+<tr><td>{{.User}}</td><td>{{.Kind}}</td><td>{{.Source}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// pseudonymizer replaces usernames with stable keyed-HMAC pseudonyms for
+// -pseudonymize. The same key always yields the same pseudonym, so reports
+// can be compared over time without naming anyone.
+type pseudonymizer struct {
+	key   []byte
+	names map[string]string // pseudonym -> username or account, for -pseudonym-map
+}
+
+// loadPseudonymizer reads the HMAC key from path, creating a random one
+// there (readable by the owner only) if the file does not exist yet.
+func loadPseudonymizer(path string) (*pseudonymizer, error) {
+	key, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		key = []byte(hex.EncodeToString(raw) + "\n")
+		if err := os.WriteFile(path, key, 0600); err != nil {
+			return nil, fmt.Errorf("failed to create key: %w", err)
+		}
+		log.Printf("[INFO] Created pseudonym key %s; reuse it to keep pseudonyms stable between reports", path)
+	} else if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("key is too short (need at least 16 bytes)")
+	}
+	return &pseudonymizer{key: key, names: make(map[string]string)}, nil
+}
+
+// name returns the pseudonym of user: "u-" and 16 hex digits of
+// HMAC-SHA256(key, user).
+func (p *pseudonymizer) name(user string) string {
+	return p.pseudonym("u-", "", user)
+}
+
+// account returns the pseudonym of a resource account (an OS, database or
+// Windows login): "a-" and 16 hex digits of HMAC-SHA256(key, "account:" +
+// account). Accounts are hashed apart from usernames, so the map tells the
+// two apart even when a login is also a username.
+func (p *pseudonymizer) account(account string) string {
+	return p.pseudonym("a-", "account:", account)
+}
+
+// pseudonym hashes domain+value under the key and records the result.
+func (p *pseudonymizer) pseudonym(prefix, domain, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(domain + value))
+	pseudonym := prefix + hex.EncodeToString(mac.Sum(nil))[:16]
+	if other, ok := p.names[pseudonym]; ok && other != value {
+		log.Fatalf("Pseudonym collision between two names (%s); use another -pseudonymize key", pseudonym)
+	}
+	p.names[pseudonym] = value
+	return pseudonym
+}
+
+// apply rewrites the report data with pseudonyms in place of usernames: the
+// per-cycle accumulators, whose summaries are rebuilt, and every table of rc
+// the report writers read users from. Resource accounts (OS, database
+// and Windows logins) are pseudonymized too, as they often are usernames,
+// but with the account pseudonyms.
+func (p *pseudonymizer) apply(accums []*cycleAccum, rc *reportContext) ([]*cycleAccum, []cycleSummary) {
+	out := make([]*cycleAccum, len(accums))
+	summaries := make([]cycleSummary, len(accums))
+	for i, a := range accums {
+		out[i] = newCycleAccum()
+		out[i].mergeAs(a, p.name)
+		for user, seen := range out[i].resources {
+			renamed := make(map[resourceKey]*resourceSeen, len(seen))
+			for _, r := range seen {
+				r.Account = p.account(r.Account)
+				renamed[r.resourceKey] = r
+			}
+			out[i].resources[user] = renamed
+		}
+		summaries[i] = out[i].summarize()
+	}
+
+	if rc.classifications != nil {
+		renamed := make(map[string]userClassification, len(rc.classifications))
+		for user, c := range rc.classifications {
+			c.User = p.name(user)
+			renamed[c.User] = c
+		}
+		rc.classifications = renamed
+	}
+	if rc.userGroups != nil {
+		renamed := make(map[string][]string, len(rc.userGroups))
+		for user, groups := range rc.userGroups {
+			renamed[p.name(user)] = groups
+		}
+		rc.userGroups = renamed
+	}
+	if rc.ssoConnectors != nil {
+		renamed := make(map[string]string, len(rc.ssoConnectors))
+		for user, connector := range rc.ssoConnectors {
+			renamed[p.name(user)] = connector
+		}
+		rc.ssoConnectors = renamed
+	}
+	for i := range rc.dormantUsers {
+		rc.dormantUsers[i].User = p.name(rc.dormantUsers[i].User)
+	}
+	sort.Slice(rc.dormantUsers, func(i, j int) bool { return rc.dormantUsers[i].User < rc.dormantUsers[j].User })
+	for i := range rc.reconciliation {
+		for j := range rc.reconciliation[i].Users {
+			u := &rc.reconciliation[i].Users[j]
+			u.User = p.name(u.User)
+		}
+	}
+	return out, summaries
+}
+
+// writeMap merges the pseudonyms used in this run into the -pseudonym-map
+// CSV, so one file reverses every report made with the same key. Each row
+// says whether the pseudonym stands for a user or a resource account; maps
+// written before accounts were told apart hold users only.
+func (p *pseudonymizer) writeMap(path string) error {
+	names := make(map[string]string, len(p.names))
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return fmt.Errorf("failed to read existing map: %w", err)
+		}
+		for _, record := range records {
+			if n := len(record); (n == 2 || n == 3) && record[0] != "pseudonym" {
+				names[record[0]] = record[n-1]
+			}
+		}
+	}
+	for pseudonym, user := range p.names {
+		names[pseudonym] = user
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"pseudonym", "type", "name"}); err != nil {
+		return err
+	}
+	for _, pseudonym := range sortedKeys(names) {
+		kind := "user"
+		if strings.HasPrefix(pseudonym, "a-") {
+			kind = "account"
+		}
+		if err := w.Write([]string{pseudonym, kind, names[pseudonym]}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
 }
 
 // billingDayAnchor mirrors the -billing-day flag so report writers can include
 // it without threading an extra argument through.
 var billingDayAnchor int
+
+// reportContext holds what the report writers show besides the per-cycle
+// accumulators. main fills it in once every lookup is done and passes it to
+// the writers; a nil or empty field leaves its section out.
+type reportContext struct {
+	window          string                        // label of the rolling or -from/-to window
+	classifications map[string]userClassification // final human/bot decision for every reported user
+	userGroups      map[string][]string           // -group-by groups of each cluster user; nil when grouping is off
+	clusterTotals   []clusterRow                  // per-cluster MAU; nil for a single cluster
+	forecasts       []forecastRow                 // forecast and licence checks for the latest cycle
+	reconciliation  []reconcileRow                // -reconcile comparison with the billed figures
+	dormantUsers    []dormantUser                 // -dormant-days result
+	ssoConnectors   map[string]string             // SSO connector of each user, for -security
+	dailySeries     []seriesPoint                 // -series daily points
+	weeklySeries    []seriesPoint                 // -series weekly points
+}
+
+// heuristicClassifications returns, sorted by user, the classifications that
+// came from a fallback heuristic rather than the cluster or the events.
+func (rc *reportContext) heuristicClassifications() []userClassification {
+	var out []userClassification
+	for _, user := range sortedKeys(rc.classifications) {
+		if c := rc.classifications[user]; c.Heuristic {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// formatHeuristicTable renders the users whose kind was guessed.
+func (rc *reportContext) formatHeuristicTable() string {
+	rows := rc.heuristicClassifications()
+	if len(rows) == 0 {
+		return ""
+	}
+
+	userColWidth := 4
+	for _, c := range rows {
+		if len(c.User) > userColWidth {
+			userColWidth = len(c.User)
+		}
+	}
+	userColWidth += 2
+
+	output := "USERS CLASSIFIED BY HEURISTIC (verify these)\n"
+	output += "-------------------------------------------------\n"
+	output += fmt.Sprintf("%-*s  %-6s  %s\n", userColWidth, "User", "Kind", "Reason")
+	output += strings.Repeat("-", userColWidth+2+6+2+12) + "\n"
+	for _, c := range rows {
+		output += fmt.Sprintf("%-*s  %-6s  %s\n", userColWidth, c.User, c.Kind, c.Source)
+	}
+	return output + "\n"
+}
+
+// reportLocation is the -tz timezone. Days, weeks, months and billing cycles
+// start at midnight in it, and report dates are shown in it.
+var reportLocation = time.UTC
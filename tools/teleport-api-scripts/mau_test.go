@@ -0,0 +1,1309 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravitational/teleport/api/types"
+	apievents "github.com/gravitational/teleport/api/types/events"
+)
+
+func TestClassifyUserKind(t *testing.T) {
+	tests := []struct {
+		name string
+		meta apievents.UserMetadata
+		want UserKindLabel
+	}{
+		{
+			name: "bot user kind",
+			meta: apievents.UserMetadata{User: "bot-ci", UserKind: apievents.UserKind_USER_KIND_BOT},
+			want: UserKindBot,
+		},
+		{
+			name: "bot name without user kind",
+			meta: apievents.UserMetadata{User: "ci", BotName: "ci"},
+			want: UserKindBot,
+		},
+		{
+			name: "human user kind",
+			meta: apievents.UserMetadata{User: "alice", UserKind: apievents.UserKind_USER_KIND_HUMAN},
+			want: UserKindHuman,
+		},
+		{
+			name: "unspecified",
+			meta: apievents.UserMetadata{User: "alice"},
+			want: UserKindUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyUserKind(tt.meta); got != tt.want {
+				t.Errorf("classifyUserKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrongerKind(t *testing.T) {
+	tests := []struct {
+		a, b UserKindLabel
+		want UserKindLabel
+	}{
+		{UserKindUnknown, UserKindUnknown, UserKindUnknown},
+		{UserKindUnknown, UserKindHuman, UserKindHuman},
+		{UserKindHuman, UserKindUnknown, UserKindHuman},
+		{UserKindHuman, UserKindBot, UserKindBot},
+		{UserKindBot, UserKindHuman, UserKindBot},
+		{UserKindBot, UserKindUnknown, UserKindBot},
+	}
+
+	for _, tt := range tests {
+		if got := strongerKind(tt.a, tt.b); got != tt.want {
+			t.Errorf("strongerKind(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		user          string
+		clusterKind   UserKindLabel
+		eventKind     UserKindLabel
+		wantKind      UserKindLabel
+		wantSource    string
+		wantHeuristic bool
+	}{
+		{
+			name:        "cluster bot",
+			user:        "bot-ci",
+			clusterKind: UserKindBot,
+			wantKind:    UserKindBot,
+			wantSource:  kindSourceCluster,
+		},
+		{
+			name:        "event bot overrides cluster human",
+			user:        "ci",
+			clusterKind: UserKindHuman,
+			eventKind:   UserKindBot,
+			wantKind:    UserKindBot,
+			wantSource:  kindSourceEvent,
+		},
+		{
+			name:        "cluster human overrides bot- prefix",
+			user:        "bot-lover",
+			clusterKind: UserKindHuman,
+			wantKind:    UserKindHuman,
+			wantSource:  kindSourceCluster,
+		},
+		{
+			name:       "event human for deleted user",
+			user:       "alice",
+			eventKind:  UserKindHuman,
+			wantKind:   UserKindHuman,
+			wantSource: kindSourceEvent,
+		},
+		{
+			name:          "bot- prefix fallback",
+			user:          "bot-deploy",
+			wantKind:      UserKindBot,
+			wantSource:    kindSourcePrefix,
+			wantHeuristic: true,
+		},
+		{
+			name:          "default fallback",
+			user:          "bob",
+			wantKind:      UserKindHuman,
+			wantSource:    kindSourceDefault,
+			wantHeuristic: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyUser(tt.user, tt.clusterKind, tt.eventKind)
+			if got.User != tt.user || got.Kind != tt.wantKind || got.Source != tt.wantSource || got.Heuristic != tt.wantHeuristic {
+				t.Errorf("classifyUser() = %+v, want kind %q source %q heuristic %v",
+					got, tt.wantKind, tt.wantSource, tt.wantHeuristic)
+			}
+		})
+	}
+}
+
+func newTestUser(t *testing.T, name string, bot bool) types.User {
+	t.Helper()
+	u, err := types.NewUser(name)
+	if err != nil {
+		t.Fatalf("NewUser(%q): %v", name, err)
+	}
+	if bot {
+		u.(*types.UserV2).Metadata.Labels = map[string]string{types.BotLabel: name}
+	}
+	return u
+}
+
+// newEvent returns an audit event of eventType by user at t. SSH sessions
+// are on web-1, and logins and database and desktop session starts succeed.
+// Tests set any further fields on the returned event.
+func newEvent(eventType, user string, at time.Time) apievents.AuditEvent {
+	meta := apievents.Metadata{Type: eventType, Time: at}
+	um := apievents.UserMetadata{User: user}
+	ok := apievents.Status{Success: true}
+	switch eventType {
+	case "user.login":
+		return &apievents.UserLogin{Metadata: meta, UserMetadata: um, Status: ok}
+	case "session.start":
+		return &apievents.SessionStart{Metadata: meta, UserMetadata: um,
+			ServerMetadata: apievents.ServerMetadata{ServerHostname: "web-1"}}
+	case "session.end":
+		return &apievents.SessionEnd{Metadata: meta, UserMetadata: um}
+	case "db.session.start":
+		return &apievents.DatabaseSessionStart{Metadata: meta, UserMetadata: um, Status: ok}
+	case "app.session.start":
+		return &apievents.AppSessionStart{Metadata: meta, UserMetadata: um}
+	case "app.session.end":
+		return &apievents.AppSessionEnd{Metadata: meta, UserMetadata: um}
+	case "windows.desktop.session.end":
+		return &apievents.WindowsDesktopSessionEnd{Metadata: meta, UserMetadata: um}
+	case "access_request.create":
+		return &apievents.AccessRequestCreate{Metadata: meta, UserMetadata: um}
+	}
+	panic("newEvent: unsupported event type " + eventType)
+}
+
+func TestClassifyUsers(t *testing.T) {
+	now := time.Now()
+
+	// The event evidence for "ci" is split across cycles and must still
+	// classify it as a bot in both.
+	first, second := newCycleAccum(), newCycleAccum()
+	ci := newEvent("session.start", "ci", now).(*apievents.SessionStart)
+	ci.UserKind, ci.BotName = apievents.UserKind_USER_KIND_BOT, "ci"
+	first.ingest(ci)
+	second.ingest(newEvent("session.start", "ci", now))
+	first.ingest(newEvent("session.start", "bot-labelled", now))
+	first.ingest(newEvent("session.start", "alice", now))
+	second.ingest(newEvent("session.start", "bot-orphan", now))
+	second.ingest(newEvent("session.start", "bob", now))
+
+	clusterUsers := []types.User{
+		newTestUser(t, "bot-labelled", true),
+		newTestUser(t, "alice", false),
+	}
+	got := classifyUsers(clusterUsers, []*cycleAccum{first, second})
+
+	want := map[string]userClassification{
+		"ci":           {User: "ci", Kind: UserKindBot, Source: kindSourceEvent},
+		"bot-labelled": {User: "bot-labelled", Kind: UserKindBot, Source: kindSourceCluster},
+		"alice":        {User: "alice", Kind: UserKindHuman, Source: kindSourceCluster},
+		"bot-orphan":   {User: "bot-orphan", Kind: UserKindBot, Source: kindSourcePrefix, Heuristic: true},
+		"bob":          {User: "bob", Kind: UserKindHuman, Source: kindSourceDefault, Heuristic: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("classifyUsers() returned %d users, want %d: %+v", len(got), len(want), got)
+	}
+	for user, w := range want {
+		if got[user] != w {
+			t.Errorf("classifyUsers()[%q] = %+v, want %+v", user, got[user], w)
+		}
+	}
+
+	if first.userKind["ci"] != UserKindBot || second.userKind["ci"] != UserKindBot {
+		t.Errorf("userKind[ci] = %q/%q, want Bot in both cycles", first.userKind["ci"], second.userKind["ci"])
+	}
+	if s := second.summarize(); s.ztaHumanCount != 1 || s.mwiBotCount != 2 {
+		t.Errorf("second cycle: ZTA humans = %d, MWI bots = %d, want 1 and 2", s.ztaHumanCount, s.mwiBotCount)
+	}
+}
+
+func TestClassifyUsersWithoutCluster(t *testing.T) {
+	a := newCycleAccum()
+	a.ingest(newEvent("session.start", "alice", time.Time{}))
+
+	got := classifyUsers(nil, []*cycleAccum{a})
+	if c := got["alice"]; c.Kind != UserKindHuman || !c.Heuristic {
+		t.Errorf("classifyUsers(nil)[alice] = %+v, want heuristic Human", c)
+	}
+}
+
+func TestResourceDetail(t *testing.T) {
+	detailMode = true
+	defer func() { detailMode = false }()
+
+	t0 := time.Date(2025, 5, 7, 9, 0, 0, 0, time.UTC)
+	orders := apievents.DatabaseMetadata{DatabaseService: "pg", DatabaseName: "orders", DatabaseUser: "reader"}
+
+	// Two days scanned separately, merged latest day first.
+	day1, day2 := newCycleAccum(), newCycleAccum()
+	for _, d := range []struct {
+		a  *cycleAccum
+		at time.Time
+	}{{day1, t0.Add(2 * time.Hour)}, {day1, t0}, {day2, t0.Add(24 * time.Hour)}} {
+		e := newEvent("db.session.start", "alice", d.at).(*apievents.DatabaseSessionStart)
+		e.DatabaseMetadata = orders
+		d.a.ingest(e)
+	}
+	ssh := newEvent("session.start", "alice", t0).(*apievents.SessionStart)
+	ssh.Login = "root"
+	day2.ingest(ssh)
+
+	merged := newCycleAccum()
+	merged.merge(day2)
+	merged.merge(day1)
+
+	got := merged.resources.sorted("alice")
+	if len(got) != 2 {
+		t.Fatalf("got %d resources, want 2: %+v", len(got), got)
+	}
+	want := []resourceSeen{
+		{resourceKey: resourceKey{Kind: "database", Name: "pg/orders", Account: "reader"}, FirstSeen: t0, LastSeen: t0.Add(24 * time.Hour), Count: 3},
+		{resourceKey: resourceKey{Kind: "ssh", Name: "web-1", Account: "root"}, FirstSeen: t0, LastSeen: t0, Count: 1},
+	}
+	for i, w := range want {
+		if *got[i] != w {
+			t.Errorf("resource %d = %+v, want %+v", i, *got[i], w)
+		}
+	}
+}
+
+func TestBuildForecasts(t *testing.T) {
+	now := time.Date(2026, 3, 16, 12, 0, 0, 0, time.UTC)
+	cycles := lastNCycles(now, 1, 2)
+
+	// Every cycle sees a new user every third day: January ends with 11
+	// users, February with 10, and March has 6 by the 16th. Both completed
+	// cycles had 6 users by day 16, so March is projected at
+	// 6 / mean(6/11, 6/10) = 10.48 -> 10, and the trend continues 11, 10 -> 9.
+	days := dailyAccums{}
+	for ci, c := range cycles {
+		for d := c.Start; d.Before(c.End) && !d.After(now); d = d.AddDate(0, 0, 3) {
+			days.day(d).ingest(&apievents.SessionStart{
+				Metadata:     apievents.Metadata{Type: "session.start", Time: d},
+				UserMetadata: apievents.UserMetadata{User: fmt.Sprintf("user-%d-%d", ci, d.Day())},
+			})
+		}
+	}
+
+	accums := make([]*cycleAccum, len(cycles))
+	summaries := make([]cycleSummary, len(cycles))
+	for i, c := range cycles {
+		accums[i] = days.fold(c.Start, c.End)
+	}
+	classifications := classifyUsers(nil, accums)
+	for i := range accums {
+		summaries[i] = accums[i].summarize()
+	}
+
+	limitZTA = 8
+	defer func() { limitZTA = 0 }()
+	got := buildForecasts(days, classifications, cycles, summaries, now)
+
+	zta := got[0]
+	if zta.Metric != metricZTA || zta.Actual != 6 || zta.Method != "accrual curve" {
+		t.Fatalf("ZTA forecast = %+v, want actual 6 by accrual curve", zta)
+	}
+	if zta.Projected == nil || *zta.Projected != 10 {
+		t.Errorf("ZTA projected = %s, want 10", formatOptional(zta.Projected))
+	}
+	if zta.Trend == nil || *zta.Trend != 9 {
+		t.Errorf("ZTA trend = %s, want 9", formatOptional(zta.Trend))
+	}
+	if zta.Status != statusProjectedOverLimit {
+		t.Errorf("ZTA status = %q, want %q", zta.Status, statusProjectedOverLimit)
+	}
+	if got[1].Status != statusNoLimit || got[2].Status != statusNoLimit {
+		t.Errorf("IG/MWI status = %q/%q, want %q", got[1].Status, got[2].Status, statusNoLimit)
+	}
+}
+
+func TestBuildSeries(t *testing.T) {
+	// Wednesday 2025-05-07 to Tuesday 2025-05-13.
+	from := time.Date(2025, 5, 7, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 5, 13, 18, 0, 0, 0, time.UTC)
+
+	days := dailyAccums{}
+	for _, e := range []apievents.AuditEvent{
+		newEvent("app.session.start", "alice", from),
+		newEvent("app.session.start", "alice", from.AddDate(0, 0, 1)),
+		newEvent("app.session.start", "bob", from.AddDate(0, 0, 1)),
+		newEvent("app.session.start", "alice", from.AddDate(0, 0, 5)), // Monday of the next week
+	} {
+		days.day(e.GetTime()).ingest(e)
+	}
+
+	daily := buildSeries(days, nil, from, to, false)
+	if len(daily) != 7 {
+		t.Fatalf("got %d daily points, want 7", len(daily))
+	}
+	if p := daily[1]; p.Start != "2025-05-08" || p.ZTA != 2 || p.New != 1 || p.Returning != 1 {
+		t.Errorf("2025-05-08 = %+v, want 2 users, 1 new, 1 returning", p)
+	}
+	if p := daily[2]; p.ZTA != 0 || p.New != 0 || p.Returning != 0 {
+		t.Errorf("2025-05-09 = %+v, want an empty day", p)
+	}
+
+	weekly := buildSeries(days, nil, from, to, true)
+	if len(weekly) != 2 {
+		t.Fatalf("got %d weekly points, want 2", len(weekly))
+	}
+	// Both weeks are clipped to the window: Wednesday to Sunday, then
+	// Monday to Tuesday.
+	if p := weekly[0]; p.Start != "2025-05-07" || p.End != "2025-05-11" || !p.Partial || p.ZTA != 2 || p.New != 2 {
+		t.Errorf("first week = %+v, want a partial week of 7-11 May with 2 new users", p)
+	}
+	if p := weekly[1]; p.Start != "2025-05-12" || p.End != "2025-05-13" || !p.Partial || p.ZTA != 1 || p.New != 0 || p.Returning != 1 {
+		t.Errorf("second week = %+v, want a partial week of 12-13 May with 1 returning user", p)
+	}
+
+	full := buildSeries(days, nil, time.Date(2025, 5, 5, 0, 0, 0, 0, time.UTC), time.Date(2025, 5, 11, 23, 0, 0, 0, time.UTC), true)
+	if len(full) != 1 || full[0].Start != "2025-05-05" || full[0].End != "2025-05-11" || full[0].Partial {
+		t.Errorf("Monday to Sunday = %+v, want one full week", full)
+	}
+
+	// An exclusive end at midnight, as with -to, adds no day after the window.
+	midnight := time.Date(2025, 5, 12, 0, 0, 0, 0, time.UTC)
+	if daily := buildSeries(days, nil, from, midnight, false); len(daily) != 5 || daily[4].Start != "2025-05-11" {
+		t.Errorf("daily points to midnight = %+v, want 7-11 May", daily)
+	}
+	if weekly := buildSeries(days, nil, time.Date(2025, 5, 5, 0, 0, 0, 0, time.UTC), midnight, true); len(weekly) != 1 || weekly[0].End != "2025-05-11" || weekly[0].Partial {
+		t.Errorf("weekly points to midnight = %+v, want one full week", weekly)
+	}
+}
+
+func TestFindDormantUsers(t *testing.T) {
+	dormantDays = 30
+	defer func() { dormantDays = 0 }()
+
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	days := dailyAccums{}
+	for _, e := range []apievents.AuditEvent{
+		newEvent("access_request.create", "active", now.AddDate(0, 0, -2)),
+		newEvent("access_request.create", "stale", now.AddDate(0, 0, -45)),
+		newEvent("access_request.create", "edge", now.AddDate(0, 0, -30)), // first day of the 30
+	} {
+		days.day(e.GetTime()).ingest(e)
+	}
+
+	idle := newTestUser(t, "idle", false)
+	idle.(*types.UserV2).Spec.Roles = []string{"editor", "access"}
+	clusterUsers := []types.User{
+		newTestUser(t, "active", false),
+		newTestUser(t, "stale", false),
+		newTestUser(t, "edge", false),
+		idle,
+		newTestUser(t, "bot-ci", true),
+	}
+
+	want := []dormantUser{
+		{User: "bot-ci", Kind: UserKindBot, Roles: []string{}},
+		{User: "idle", Kind: UserKindHuman, Roles: []string{"access", "editor"}},
+		{User: "stale", Kind: UserKindHuman, Roles: []string{}, LastSeen: "2025-05-16"},
+	}
+	// A window ending at midnight ends on the day before, like one ending
+	// later that day.
+	for _, end := range []time.Time{now, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)} {
+		got := findDormantUsers(clusterUsers, days, end)
+		if len(got) != len(want) {
+			t.Fatalf("findDormantUsers(%s) = %+v, want %+v", end, got, want)
+		}
+		for i := range want {
+			if fmt.Sprint(got[i]) != fmt.Sprint(want[i]) {
+				t.Errorf("end %s: dormant user %d = %+v, want %+v", end, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestReadInputs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, lines ...string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data := strings.Join(lines, "\n") + "\n"
+		if !strings.HasSuffix(name, ".gz") {
+			if _, err := f.WriteString(data); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		gz := gzip.NewWriter(f)
+		if _, err := gz.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An S3-style layout: one compressed object per day, plus a plain export.
+	write("events/2025-05-07/0001.jsonl.gz",
+		`{"event":"user.login","code":"T1000I","time":"2025-05-07T09:00:00Z","user":"alice","success":true}`,
+		`{"event":"user.login","code":"T1000I","time":"2025-05-07T09:05:00Z","user":"ci","user_kind":"USER_KIND_BOT","success":true}`,
+	)
+	write("events/2025-05-08/0001.jsonl.gz",
+		`{"event":"session.start","code":"T2000I","time":"2025-05-08T10:00:00Z","user":"alice","server_hostname":"web-1"}`,
+		`{"event":"session.end","code":"T2004I","time":"2025-05-08T11:00:00Z","user":"alice"}`,
+	)
+	write("export.json",
+		`{"event":"access_request.create","code":"T5000I","time":"2025-05-09T08:00:00Z","user":"bob","id":"r1","state":"PENDING"}`,
+		`not json`,
+		``,
+	)
+	write("events/README.txt", "ignored")
+
+	files, err := inputFiles([]string{filepath.Join(dir, "events"), filepath.Join(dir, "export.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("inputFiles() = %v, want the two .jsonl.gz objects and export.json", files)
+	}
+
+	trackedTypes := []string{"user.login", "session.start", "access_request.create"}
+	days, latest, err := readInputs([]string{dir}, trackedTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 5, 9, 8, 0, 0, 0, time.UTC); !latest.Equal(want) {
+		t.Errorf("latest = %s, want %s", latest, want)
+	}
+	if len(days) != 3 {
+		t.Fatalf("got %d days, want 3", len(days))
+	}
+
+	a := days.fold(time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	if u := a.userResourceUsage["alice"]; u == nil || u.LoginCount != 1 || u.SSH != 1 {
+		t.Errorf("alice = %+v, want 1 login and 1 ssh session", u)
+	}
+	if a.userKind["ci"] != UserKindBot {
+		t.Errorf("ci kind = %q, want %q from the string user_kind", a.userKind["ci"], UserKindBot)
+	}
+	if u := a.userIGUsage["bob"]; u == nil || u.AccessRequestsCreated != 1 {
+		t.Errorf("bob = %+v, want 1 access request", u)
+	}
+
+	days.trim(time.Date(2025, 5, 8, 12, 0, 0, 0, time.UTC), latest)
+	if len(days) != 2 {
+		t.Errorf("trim kept %d days, want 2", len(days))
+	}
+}
+
+func TestCombineClusters(t *testing.T) {
+	dedupeBy = "email"
+	defer func() { dedupeBy = "username" }()
+
+	at := time.Date(2025, 5, 7, 9, 0, 0, 0, time.UTC)
+	withEmail := func(name, email string) types.User {
+		u := newTestUser(t, name, false)
+		u.(*types.UserV2).Spec.Traits = map[string][]string{"email": {email}}
+		return u
+	}
+
+	a := clusterRun{Name: "a.example.com", Days: dailyAccums{}, Users: []types.User{
+		withEmail("alice", "alice@example.com"),
+		newTestUser(t, "bot-ci", true),
+	}}
+	for _, user := range []string{"alice", "bot-ci"} {
+		a.Days.day(at).ingest(newEvent("session.start", user, at))
+	}
+
+	b := clusterRun{Name: "b.example.com", Days: dailyAccums{}, Users: []types.User{
+		withEmail("asmith", "Alice@Example.com"),
+		newTestUser(t, "carol", false),
+		newTestUser(t, "bot-ci", true),
+	}}
+	for _, user := range []string{"asmith", "carol", "bot-ci"} {
+		b.Days.day(at).ingest(newEvent("session.start", user, at))
+	}
+
+	runs := []clusterRun{a, b}
+	for i := range runs {
+		runs[i].classify()
+	}
+	days, classes := combineClusters(runs)
+
+	cycles := []cycleBounds{{Start: at.AddDate(0, 0, -1), End: at.AddDate(0, 0, 1), Label: "Test"}}
+	combined := []*cycleAccum{days.fold(cycles[0].Start, cycles[0].End)}
+	s := combined[0].summarize()
+	if s.ztaHumanCount != 2 || s.mwiBotCount != 2 {
+		t.Errorf("combined ZTA = %d, MWI = %d, want alice once plus carol, and one bot per cluster", s.ztaHumanCount, s.mwiBotCount)
+	}
+	if u := s.ztaMAUAll["alice@example.com"]; u == nil || u.SSH != 2 {
+		t.Errorf("alice@example.com = %+v, want the sessions of both accounts", u)
+	}
+	if c := classes["bot-ci@b.example.com"]; c.Kind != UserKindBot || c.Source != kindSourceCluster {
+		t.Errorf("bot-ci@b.example.com = %+v, want a cluster-labelled bot", c)
+	}
+
+	want := []clusterRow{
+		{"a.example.com", "Test", 1, 0, 1, 0},
+		{"b.example.com", "Test", 2, 0, 1, 0},
+		{combinedCluster, "Test", 2, 0, 2, 0},
+	}
+	got := buildClusterTotals(runs, cycles, combined)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("buildClusterTotals() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEventMapping(t *testing.T) {
+	if got := len(mapping.eventTypes()); got != 12 {
+		t.Errorf("built-in mapping tracks %d event types, want 12", got)
+	}
+	if _, err := newEventMapping([]eventRule{{Event: "git.command", Column: "git"}}); err == nil {
+		t.Error("newEventMapping() accepted an unknown column")
+	}
+	if _, err := newEventMapping([]eventRule{
+		{Event: "user.login", Column: "login_count"},
+		{Event: "user.login", Column: "ssh"},
+	}); err == nil {
+		t.Error("newEventMapping() accepted the same event twice")
+	}
+
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	config := `{"rules": [
+		{"event": "session.start", "column": "ssh"},
+		{"event": "git.command", "column": "ssh"},
+		{"event": "mcp.session.start", "column": "application"},
+		{"event": "sftp", "code": "T3008I", "column": "ssh"}
+	]}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadEventMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev eventMapping) { mapping = prev }(mapping)
+	mapping = m
+
+	at := time.Date(2025, 5, 7, 9, 0, 0, 0, time.UTC)
+	a := newCycleAccum()
+	for _, e := range []struct{ eventType, code, user string }{
+		{"git.command", "TGIT001I", "alice"},
+		{"mcp.session.start", "TMCP001I", "alice"},
+		{"sftp", "T3008I", "bob"},
+		{"sftp", "T3009I", "carol"},      // another code of a code-specific rule
+		{"user.login", "T1000I", "dave"}, // no longer mapped
+	} {
+		a.ingestFields(&eventFields{
+			Metadata:     apievents.Metadata{Type: e.eventType, Code: e.code, Time: at},
+			UserMetadata: apievents.UserMetadata{User: e.user},
+		})
+	}
+
+	if u := a.userResourceUsage["alice"]; u == nil || u.SSH != 1 || u.Application != 1 {
+		t.Errorf("alice = %+v, want 1 ssh (git) and 1 application (mcp)", u)
+	}
+	if u := a.userResourceUsage["bob"]; u == nil || u.SSH != 1 {
+		t.Errorf("bob = %+v, want 1 ssh (sftp)", u)
+	}
+	for _, user := range []string{"carol", "dave"} {
+		if _, ok := a.userKind[user]; ok {
+			t.Errorf("%s was tracked by an unmapped event", user)
+		}
+	}
+
+	// Skipped events are reported: unmapped codes and types, events
+	// without a user, and types the API could not decode.
+	a.ingestFields(&eventFields{Metadata: apievents.Metadata{Type: "git.command", Time: at}})
+	a.ingest(&apievents.Unknown{Metadata: apievents.Metadata{Type: "unknown", Time: at}, UnknownType: "workload.created"})
+	want := map[string]int{"sftp": 1, "user.login": 1, "git.command": 1, "workload.created": 1}
+	if !reflect.DeepEqual(a.unrecognized, want) {
+		t.Errorf("unrecognized = %v, want %v", a.unrecognized, want)
+	}
+}
+
+func TestSecuritySignals(t *testing.T) {
+	securityMode, sessionMode = true, true
+	defer func() { securityMode, sessionMode = false, false }()
+
+	at := time.Date(2025, 5, 7, 9, 0, 0, 0, time.UTC)
+	a := newCycleAccum()
+	for _, l := range []struct {
+		user, method string
+		success      bool
+		mfa          *apievents.MFADeviceMetadata
+	}{
+		{"alice", "saml", true, nil},
+		{"alice", "local", true, &apievents.MFADeviceMetadata{DeviceType: "WebAuthn"}},
+		{"alice", "local", false, nil},
+		{"mallory", "local", false, nil},
+	} {
+		e := newEvent("user.login", l.user, at).(*apievents.UserLogin)
+		e.Method, e.Success, e.MFADevice = l.method, l.success, l.mfa
+		a.ingest(e)
+	}
+	ssh := newEvent("session.start", "alice", at).(*apievents.SessionStart)
+	ssh.TrustedDevice = &apievents.DeviceMetadata{DeviceId: "d1"}
+	ssh.SessionID, ssh.WithMFA = "s1", "d2"
+	a.ingest(ssh)
+	// The end of the session is scanned for -sessions only and adds no
+	// device or MFA signal of its own.
+	end := newEvent("session.end", "alice", at.Add(time.Hour)).(*apievents.SessionEnd)
+	end.SessionID = "s1"
+	a.ingest(end)
+
+	rows := (&reportContext{}).securityRows(a.signals)
+	if len(rows) != 2 {
+		t.Fatalf("got %d security rows, want alice and mallory", len(rows))
+	}
+	alice := rows[0]
+	if alice.Logins != 2 || alice.FailedLogins != 1 || alice.SessionsWithMFA != 1 ||
+		alice.TrustedDevice != 1 || alice.UntrustedDevice != 3 {
+		t.Errorf("alice = %+v", alice)
+	}
+	if got := formatCounts(alice.Methods, ", "); got != "local=2, saml=1" {
+		t.Errorf("alice methods = %q", got)
+	}
+	if got := formatCounts(alice.MFADevices, ", "); got != "WebAuthn=1" {
+		t.Errorf("alice MFA devices = %q", got)
+	}
+	if mallory := rows[1]; mallory.User != "mallory" || mallory.FailedLogins != 1 {
+		t.Errorf("mallory = %+v, want 1 failed login", mallory)
+	}
+
+	// The security section never changes the MAU numbers.
+	s := a.summarize()
+	if s.ztaHumanCount != 1 || a.totalLogins != 2 {
+		t.Errorf("ZTA MAU = %d, logins = %d, want alice only with 2 logins", s.ztaHumanCount, a.totalLogins)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	now := time.Date(2025, 6, 20, 12, 0, 0, 0, time.UTC)
+	cycles := lastNCycles(now, 7, 1) // 7 May - 6 Jun, then 7 Jun - now
+
+	days := dailyAccums{}
+	for _, s := range []struct {
+		user  string
+		month time.Month
+		day   int
+	}{
+		{"alice", 5, 10},
+		{"alice", 5, 20},
+		{"bob", 5, 7},   // first day of May
+		{"carol", 6, 6}, // last day of May
+		{"bot-x", 5, 15},
+		{"dave", 6, 7}, // first day of June
+	} {
+		e := newEvent("session.start", s.user, time.Date(2025, s.month, s.day, 10, 0, 0, 0, time.UTC)).(*apievents.SessionStart)
+		if s.user != "bot-x" {
+			e.UserKind = apievents.UserKind_USER_KIND_HUMAN
+		}
+		days.day(e.Time).ingest(e)
+	}
+
+	accums := make([]*cycleAccum, len(cycles))
+	summaries := make([]cycleSummary, len(cycles))
+	for i, c := range cycles {
+		accums[i] = days.fold(c.Start, c.End)
+	}
+	classifications := classifyUsers(nil, accums)
+	for i := range accums {
+		summaries[i] = accums[i].summarize()
+	}
+
+	path := filepath.Join(t.TempDir(), "billed.csv")
+	data := "cycle_start,zta_mau,ig_mau,mwi_bots,invoice\n" +
+		"2025-01-07,5,0,0,INV-1\n" +
+		"2025-05-07,1,,0,INV-2\n" +
+		"2025-06-07,2,0,,INV-3\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	billed, err := loadBilledCycles(path)
+	if err != nil {
+		t.Fatalf("loadBilledCycles: %v", err)
+	}
+
+	got := reconcile(billed, cycles, summaries, days, classifications)
+	if len(got) != 4 {
+		t.Fatalf("got %d rows, want May ZTA/MWI and June ZTA/IG: %+v", len(got), got)
+	}
+
+	mayZTA := got[0]
+	if mayZTA.Metric != metricZTA || mayZTA.Reported != 3 || mayZTA.Difference != -2 {
+		t.Errorf("May ZTA = %+v, want reported 3, difference -2", mayZTA)
+	}
+	want := []reconcileUser{{"bob", true, reasonFirstDay}, {"carol", true, reasonLastDay}}
+	if !reflect.DeepEqual(mayZTA.Users, want) {
+		t.Errorf("May ZTA users = %+v, want %+v", mayZTA.Users, want)
+	}
+
+	mayMWI := got[1]
+	if mayMWI.Metric != metricMWI || mayMWI.Difference != -1 || len(mayMWI.Users) != 1 ||
+		mayMWI.Users[0].User != "bot-x" || !mayMWI.Users[0].Counted {
+		t.Errorf("May MWI = %+v, want bot-x counted by heuristic", mayMWI)
+	}
+
+	// June billed one more user than reported; carol, active the day
+	// before, is the candidate. The cycle is in progress, so no day after.
+	juneZTA := got[2]
+	want = []reconcileUser{{"carol", false, reasonDayBefore}}
+	if juneZTA.Difference != 1 || !reflect.DeepEqual(juneZTA.Users, want) {
+		t.Errorf("June ZTA = %+v, want +1 explained by %+v", juneZTA, want)
+	}
+	if juneIG := got[3]; juneIG.Difference != 0 || juneIG.Users != nil {
+		t.Errorf("June IG = %+v, want no difference", juneIG)
+	}
+}
+
+func TestReportingPeriods(t *testing.T) {
+	defer func() { reportLocation = time.UTC }()
+
+	// In UTC, 20:00 on 6 May is still in the April billing cycle; in Tokyo
+	// it is already 7 May, the first day of the next one.
+	at := time.Date(2025, 5, 6, 20, 0, 0, 0, time.UTC)
+	if got := cycleContaining(at, 7); got.Label != "7 Apr 2025 - 6 May 2025" {
+		t.Errorf("UTC cycle = %q", got.Label)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportLocation = tokyo
+	c := cycleContaining(at, 7)
+	if c.Label != "7 May 2025 - 6 Jun 2025" || !c.Start.Equal(time.Date(2025, 5, 6, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("Tokyo cycle = %q starting %s", c.Label, c.Start.UTC())
+	}
+
+	// Days follow the timezone across a DST change: 9 March 2025 in New
+	// York is 23 hours long.
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportLocation = newYork
+	days := dailyAccums{}
+	for _, ts := range []string{"2025-03-09T04:30:00Z", "2025-03-09T05:30:00Z", "2025-03-10T03:30:00Z"} {
+		et, _ := time.Parse(time.RFC3339, ts)
+		days.day(et).totalLogins++
+	}
+	got := days.sortedDays()
+	if len(got) != 2 || got[0].Format("2006-01-02") != "2025-03-08" || got[1].Format("2006-01-02") != "2025-03-09" {
+		t.Fatalf("New York days = %v, want 8 and 9 March", got)
+	}
+	if got[1].AddDate(0, 0, 1).Sub(got[1]) != 23*time.Hour || days[got[1]].totalLogins != 2 {
+		t.Errorf("9 March = %v with %d logins, want 23h and 2 logins", got[1].AddDate(0, 0, 1).Sub(got[1]), days[got[1]].totalLogins)
+	}
+
+	reportLocation = time.UTC
+	if w := weekContaining(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)); w.Label != "2025-W01: 30 Dec - 5 Jan 2025" {
+		t.Errorf("week = %q", w.Label)
+	}
+	months := periodsBetween(time.Date(2025, 5, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 7, 3, 0, 0, 0, 0, time.UTC), monthContaining)
+	var labels []string
+	for _, m := range months {
+		labels = append(labels, m.Label)
+	}
+	if strings.Join(labels, ", ") != "May 2025, June 2025, July 2025" {
+		t.Errorf("months = %v", labels)
+	}
+}
+
+func TestShardWindow(t *testing.T) {
+	defer func() { reportLocation = time.UTC }()
+	at := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	bounds := func(shards []timeShard) string {
+		var out []string
+		for _, s := range shards {
+			out = append(out, s.From.UTC().Format("01-02T15")+"/"+s.To.UTC().Format("01-02T15"))
+		}
+		return strings.Join(out, " ")
+	}
+
+	from, to := at("2025-05-06T10:00:00Z"), at("2025-05-08T12:00:00Z")
+	days := shardWindow(from, to, "day", nil)
+	if got := bounds(days); got != "05-06T10/05-07T00 05-07T00/05-08T00 05-08T00/05-08T12" {
+		t.Errorf("UTC day shards = %s", got)
+	}
+	for i, s := range days {
+		if s.Last != (i == len(days)-1) {
+			t.Errorf("shard %d Last = %v", i, s.Last)
+		}
+	}
+
+	// Day shards split at -tz midnight: 15:00 UTC in Tokyo.
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportLocation = tokyo
+	if got := bounds(shardWindow(from, to, "day", nil)); got != "05-06T10/05-06T15 05-06T15/05-07T15 05-07T15/05-08T12" {
+		t.Errorf("Tokyo day shards = %s", got)
+	}
+	reportLocation = time.UTC
+
+	// Only cycle starts strictly inside the window split it.
+	cycles := []cycleBounds{
+		{Start: at("2025-04-07T00:00:00Z"), End: at("2025-05-07T00:00:00Z")},
+		{Start: at("2025-05-07T00:00:00Z"), End: at("2025-06-07T00:00:00Z")},
+		{Start: at("2025-06-07T00:00:00Z"), End: at("2025-07-07T00:00:00Z")},
+	}
+	if got := bounds(shardWindow(from, to, "cycle", cycles)); got != "05-06T10/05-07T00 05-07T00/05-08T12" {
+		t.Errorf("cycle shards = %s", got)
+	}
+	if got := bounds(shardWindow(at("2025-05-07T00:00:00Z"), to, "cycle", cycles)); got != "05-07T00/05-08T12" {
+		t.Errorf("cycle shards from a cycle start = %s", got)
+	}
+	if got := shardWindow(from, to, "none", cycles); len(got) != 1 || !got[0].Last {
+		t.Errorf("none = %+v, want one final shard", got)
+	}
+
+	// A boundary belongs to the shard it starts; only the final shard keeps
+	// events stamped exactly at its end.
+	boundary := days[0].To
+	if days[0].contains(boundary) || !days[1].contains(boundary) {
+		t.Errorf("boundary %s is not in exactly the later shard", boundary)
+	}
+	if !days[2].contains(to) || days[2].contains(to.Add(time.Nanosecond)) {
+		t.Errorf("final shard does not end inclusively at %s", to)
+	}
+	if days[1].contains(days[1].From.Add(-time.Nanosecond)) {
+		t.Errorf("shard starts before %s", days[1].From)
+	}
+}
+
+func TestMergeShardsInAnyOrder(t *testing.T) {
+	from := time.Date(2025, 5, 6, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 5, 9, 0, 0, 0, 0, time.UTC)
+	shards := shardWindow(from, to, "day", nil)
+
+	var events []apievents.AuditEvent
+	for i, user := range []string{"alice", "bob", "alice", "bot-ci", "carol", "alice"} {
+		at := from.Add(time.Duration(i) * 11 * time.Hour)
+		events = append(events, newEvent("session.start", user, at), newEvent("user.login", user, at))
+	}
+	events = append(events, newEvent("db.session.start", "bob", shards[1].From))
+
+	want := dailyAccums{}
+	results := make([]dailyAccums, len(shards))
+	for i := range results {
+		results[i] = dailyAccums{}
+	}
+	for _, e := range events {
+		want.day(e.GetTime()).ingest(e)
+		for i, s := range shards {
+			if s.contains(e.GetTime()) {
+				results[i].day(e.GetTime()).ingest(e)
+			}
+		}
+	}
+
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 0, 2}, {2, 0, 1}} {
+		got := dailyAccums{}
+		for _, i := range order {
+			got.merge(results[i])
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("merging shards in order %v differs from a single scan", order)
+		}
+		if g, w := got.fold(from, to).summarize(), want.fold(from, to).summarize(); !reflect.DeepEqual(g, w) {
+			t.Errorf("order %v: summary %+v, want %+v", order, g.counts(), w.counts())
+		}
+	}
+}
+
+func TestPseudonymize(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key")
+	p, err := loadPseudonymizer(keyPath)
+	if err != nil {
+		t.Fatalf("loadPseudonymizer: %v", err)
+	}
+	again, err := loadPseudonymizer(keyPath)
+	if err != nil {
+		t.Fatalf("loadPseudonymizer (existing key): %v", err)
+	}
+	if p.name("alice") != again.name("alice") || p.name("alice") == p.name("bob") {
+		t.Fatalf("pseudonyms are not stable per key and distinct per user")
+	}
+
+	at := time.Date(2025, 5, 7, 9, 0, 0, 0, time.UTC)
+	detailMode = true
+	defer func() { detailMode = false }()
+	a := newCycleAccum()
+	ssh := newEvent("session.start", "alice", at).(*apievents.SessionStart)
+	ssh.Login = "alice"
+	a.ingest(ssh)
+	a.ingest(newEvent("access_request.create", "bob", at))
+	rc := &reportContext{
+		classifications: classifyUsers(nil, []*cycleAccum{a}),
+		dormantUsers:    []dormantUser{{User: "carol", Kind: UserKindHuman}},
+	}
+
+	accums, summaries := p.apply([]*cycleAccum{a}, rc)
+	s := summaries[0]
+	report := formatUserTables(s.ztaMAUAll, s.igMAUAll, accums[0].userKind) +
+		formatResourceTable(accums[0].resources) + rc.formatHeuristicTable() + rc.formatDormantTable()
+	data, err := json.Marshal(map[string]interface{}{"user_kind": accums[0].userKind, "zta": s.ztaMAUAll, "ig": s.igMAUAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if strings.Contains(report, user) || strings.Contains(string(data), user) {
+			t.Errorf("report still names %s", user)
+		}
+		if !strings.Contains(report, p.name(user)) {
+			t.Errorf("report does not list the pseudonym of %s", user)
+		}
+	}
+	if s.ztaHumanCount != 1 || s.igHumanCount != 1 {
+		t.Errorf("ZTA/IG MAU = %d/%d, want 1/1", s.ztaHumanCount, s.igHumanCount)
+	}
+	// The login is an account, not the user of the same name.
+	if account := p.account("alice"); account == p.name("alice") || !strings.Contains(report, account) {
+		t.Errorf("login alice = %s, want an account pseudonym apart from user alice", account)
+	}
+
+	// The map accumulates across runs.
+	mapPath := filepath.Join(dir, "map.csv")
+	other := &pseudonymizer{key: []byte("another key, 16+ bytes"), names: map[string]string{}}
+	other.name("dave")
+	for _, q := range []*pseudonymizer{other, p} {
+		if err := q.writeMap(mapPath); err != nil {
+			t.Fatalf("writeMap: %v", err)
+		}
+	}
+	written, err := os.ReadFile(mapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{
+		p.name("alice") + ",user,alice",
+		p.account("alice") + ",account,alice",
+		p.name("bob") + ",user,bob",
+		p.name("carol") + ",user,carol",
+		other.name("dave") + ",user,dave",
+	} {
+		if !strings.Contains(string(written), row+"\n") {
+			t.Errorf("map is missing %s:\n%s", row, written)
+		}
+	}
+}
+
+func TestSessionDurations(t *testing.T) {
+	sessionMode = true
+	defer func() { sessionMode = false }()
+
+	at := func(day, hour int) time.Time { return time.Date(2025, 6, day, hour, 0, 0, 0, time.UTC) }
+	from, to := at(1, 0), at(3, 0)
+	cycles := []cycleBounds{
+		{Start: from, End: at(2, 0), Label: "1 Jun"},
+		{Start: at(2, 0), End: to, Label: "2 Jun"},
+	}
+	// A paired SSH session of an hour.
+	s1 := newEvent("session.start", "alice", at(1, 10)).(*apievents.SessionStart)
+	s1.SessionID = "s1"
+	s1End := newEvent("session.end", "alice", at(1, 11)).(*apievents.SessionEnd)
+	s1End.SessionID, s1End.StartTime, s1End.EndTime = "s1", at(1, 10), at(1, 11)
+
+	// A Kubernetes session over the cycle boundary.
+	k1 := newEvent("session.start", "alice", at(1, 23)).(*apievents.SessionStart)
+	k1.SessionID, k1.KubernetesCluster = "k1", "prod"
+	k1End := newEvent("session.end", "alice", at(2, 1)).(*apievents.SessionEnd)
+	k1End.SessionID, k1End.KubernetesCluster, k1End.StartTime, k1End.EndTime = "k1", "prod", at(1, 23), at(2, 1)
+
+	// Still open at the end of the window; the failed attempt opens nothing.
+	d1 := newEvent("db.session.start", "bob", at(2, 20)).(*apievents.DatabaseSessionStart)
+	d1.SessionID = "d1"
+	d2 := newEvent("db.session.start", "bob", at(2, 21)).(*apievents.DatabaseSessionStart)
+	d2.SessionID, d2.Success = "d2", false
+
+	// Started before the window.
+	w1End := newEvent("windows.desktop.session.end", "carol", at(1, 2)).(*apievents.WindowsDesktopSessionEnd)
+	w1End.SessionID, w1End.StartTime, w1End.EndTime = "w1", at(1, 0).Add(-2*time.Hour), at(1, 2)
+
+	// An app session end carries no start: at most openSessionLimit is counted.
+	a1End := newEvent("app.session.end", "dave", at(2, 6)).(*apievents.AppSessionEnd)
+	a1End.SessionID = "a1"
+
+	days := dailyAccums{}
+	for _, e := range []apievents.AuditEvent{s1, s1End, k1, k1End, d1, d2, w1End, a1End} {
+		days.day(e.GetTime()).ingest(e)
+	}
+
+	accums := []*cycleAccum{days.fold(cycles[0].Start, cycles[0].End), days.fold(cycles[1].Start, cycles[1].End)}
+	attributeSessions(days.fold(from, to).sessions, cycles, accums, from, to)
+
+	type usage struct {
+		sessions, open int
+		connected      time.Duration
+	}
+	want := []map[string]usage{
+		{
+			"alice/ssh":        {1, 0, time.Hour},
+			"alice/kubernetes": {1, 0, time.Hour},
+			"carol/desktop":    {1, 0, 2 * time.Hour},
+			"dave/application": {1, 0, 18 * time.Hour},
+		},
+		{
+			"alice/kubernetes": {0, 0, time.Hour},
+			"bob/database":     {1, 1, 4 * time.Hour},
+			"dave/application": {0, 0, 6 * time.Hour},
+		},
+	}
+	for i := range cycles {
+		got := make(map[string]usage)
+		for _, r := range sessionRows(accums[i].connected) {
+			got[r.User+"/"+r.Category] = usage{r.Sessions, r.Open, time.Duration(r.ConnectedSeconds) * time.Second}
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("cycle %s: got %v, want %v", cycles[i].Label, got, want[i])
+		}
+	}
+
+	// Pairing never changes the MAU numbers.
+	if s := accums[1].summarize(); s.ztaHumanCount != 1 {
+		t.Errorf("ZTA MAU = %d, want bob only", s.ztaHumanCount)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	detailMode, securityMode, sessionMode = true, true, true
+	defer func() { detailMode, securityMode, sessionMode = false, false, false }()
+
+	store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	from := time.Date(2025, 5, 6, 0, 0, 0, 0, time.UTC)
+	start := newEvent("session.start", "alice", from.Add(9*time.Hour)).(*apievents.SessionStart)
+	start.SessionID = "s1"
+	end := newEvent("session.end", "alice", from.Add(10*time.Hour)).(*apievents.SessionEnd)
+	end.SessionID = "s1"
+	bot := newEvent("session.start", "ci", from.Add(24*time.Hour)).(*apievents.SessionStart)
+	bot.UserKind, bot.BotName = apievents.UserKind_USER_KIND_BOT, "ci"
+
+	days := dailyAccums{}
+	for _, e := range []apievents.AuditEvent{
+		newEvent("user.login", "alice", from.Add(8*time.Hour)),
+		start, end, bot,
+		newEvent("db.session.start", "alice", from.Add(25*time.Hour)),
+		newEvent("access_request.create", "bob", from.Add(26*time.Hour)),
+	} {
+		days.day(e.GetTime()).ingest(e)
+	}
+
+	to := from.AddDate(0, 0, 2)
+	if err := store.save(days, from, to, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := store.load(from, to)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(got.sortedDays(), days.sortedDays()) {
+		t.Fatalf("loaded days %v, want %v", got.sortedDays(), days.sortedDays())
+	}
+	for _, day := range days.sortedDays() {
+		g, w := got[day].summarize(), days[day].summarize()
+		if !reflect.DeepEqual(g.counts(), w.counts()) || !reflect.DeepEqual(g.ztaMAUAll, w.ztaMAUAll) || !reflect.DeepEqual(g.igMAUAll, w.igMAUAll) {
+			t.Errorf("%s: loaded %+v, want %+v", day.Format(storeDayFormat), g.counts(), w.counts())
+		}
+		for _, field := range []struct {
+			name      string
+			got, want interface{}
+		}{
+			{"kinds", got[day].userKind, days[day].userKind},
+			{"logins", got[day].totalLogins, days[day].totalLogins},
+			{"resources", got[day].resources, days[day].resources},
+			{"signals", got[day].signals, days[day].signals},
+			{"sessions", got[day].sessions, days[day].sessions},
+		} {
+			if !reflect.DeepEqual(field.got, field.want) {
+				t.Errorf("%s %s: loaded %+v, want %+v", day.Format(storeDayFormat), field.name, field.got, field.want)
+			}
+		}
+	}
+}
+
+func TestSyncStore(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// One login a day from 1 to 10 May.
+	day := func(d int) time.Time { return time.Date(2025, 5, d, 0, 0, 0, 0, time.UTC) }
+	var events []apievents.AuditEvent
+	for d := 1; d <= 10; d++ {
+		events = append(events, newEvent("user.login", "alice", day(d).Add(12*time.Hour)))
+	}
+	var scanned []string
+	scan := func(from, to time.Time) (dailyAccums, error) {
+		scanned = append(scanned, from.Format("02")+"-"+to.Format("02"))
+		days := dailyAccums{}
+		for _, e := range events {
+			if !e.GetTime().Before(from) && e.GetTime().Before(to) {
+				days.day(e.GetTime()).ingest(e)
+			}
+		}
+		return days, nil
+	}
+	logins := func(days dailyAccums) int {
+		n := 0
+		for _, a := range days {
+			n += a.totalLogins
+		}
+		return n
+	}
+
+	for _, step := range []struct {
+		from, to    int
+		wantScanned string
+		wantLogins  int
+	}{
+		{4, 7, "04-07", 3},
+		// Extending the window before and after scans only the two gaps.
+		{2, 9, "02-04 07-09", 7},
+		// Syncing a covered window again scans nothing and counts nothing twice.
+		{2, 9, "", 7},
+		{5, 6, "", 1},
+	} {
+		scanned = nil
+		days, err := syncStore(store, day(step.from), day(step.to), scan)
+		if err != nil {
+			t.Fatalf("sync %d-%d: %v", step.from, step.to, err)
+		}
+		if got := strings.Join(scanned, " "); got != step.wantScanned {
+			t.Errorf("sync %d-%d scanned %q, want %q", step.from, step.to, got, step.wantScanned)
+		}
+		if got := logins(days); got != step.wantLogins {
+			t.Errorf("sync %d-%d loaded %d logins, want %d", step.from, step.to, got, step.wantLogins)
+		}
+	}
+
+	coveredFrom, scannedThrough, ok, err := store.checkpoint()
+	if err != nil || !ok || !coveredFrom.Equal(day(2)) || !scannedThrough.Equal(day(9)) {
+		t.Errorf("checkpoint = %s - %s (%v, %v), want 2 - 9 May", coveredFrom, scannedThrough, ok, err)
+	}
+}
+
+func TestSyncStoreCollectors(t *testing.T) {
+	defer func() { detailMode, securityMode, sessionMode = false, false, false }()
+	store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// One SSH session a day from 1 to 10 May; days before retain are no
+	// longer in the audit log.
+	day := func(d int) time.Time { return time.Date(2025, 5, d, 0, 0, 0, 0, time.UTC) }
+	retain := day(1)
+	var scanned []string
+	scan := func(from, to time.Time) (dailyAccums, error) {
+		scanned = append(scanned, from.Format("02")+"-"+to.Format("02"))
+		days := dailyAccums{}
+		for d := 1; d <= 10; d++ {
+			if at := day(d).Add(12 * time.Hour); !at.Before(from) && at.Before(to) && !at.Before(retain) {
+				days.day(at).ingest(newEvent("session.start", "alice", at))
+			}
+		}
+		return days, nil
+	}
+	sync := func(from, to int) (sessions, resources int) {
+		t.Helper()
+		scanned = nil
+		days, err := syncStore(store, day(from), day(to), scan)
+		if err != nil {
+			t.Fatalf("sync %d-%d: %v", from, to, err)
+		}
+		for _, a := range days {
+			if u := a.userResourceUsage["alice"]; u != nil {
+				sessions += u.SSH
+			}
+			resources += len(a.resources["alice"])
+		}
+		return sessions, resources
+	}
+
+	if n, _ := sync(2, 8); n != 6 {
+		t.Fatalf("got %d sessions, want 6", n)
+	}
+
+	// Asking for -detail rescans the stored days once and replaces them.
+	detailMode = true
+	retain = day(4)
+	n, r := sync(3, 8)
+	if got := strings.Join(scanned, " "); got != "03-08" {
+		t.Errorf("-detail scanned %q, want the stored days 3-8 again", got)
+	}
+	// 3 May is past retention: its count is kept, without detail.
+	if n != 5 || r != 4 {
+		t.Errorf("got %d sessions and %d resources, want 5 and 4", n, r)
+	}
+	if n, r := sync(4, 9); n != 5 || r != 5 || strings.Join(scanned, " ") != "08-09" {
+		t.Errorf("second -detail sync scanned %q and got %d sessions and %d resources, want 8-9, 5 and 5", strings.Join(scanned, " "), n, r)
+	}
+
+	// Turning -detail off adds days without it, so turning it back on
+	// rescans only those.
+	detailMode = false
+	sync(4, 10)
+	detailMode = true
+	if n, r := sync(4, 10); n != 6 || r != 6 || strings.Join(scanned, " ") != "09-10" {
+		t.Errorf("re-enabled -detail scanned %q and got %d sessions and %d resources, want 9-10, 6 and 6", strings.Join(scanned, " "), n, r)
+	}
+
+	// -security and -sessions are tracked the same way.
+	for _, on := range []*bool{&securityMode, &sessionMode} {
+		*on = true
+		if n, _ := sync(4, 10); n != 6 || strings.Join(scanned, " ") != "04-10" {
+			t.Errorf("scanned %q and got %d sessions, want 4-10 and 6", strings.Join(scanned, " "), n)
+		}
+	}
+	if sync(4, 10); len(scanned) != 0 {
+		t.Errorf("scanned %q with every collector stored", strings.Join(scanned, " "))
+	}
+}
+
+func TestStoreMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	store, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	defer func(prev eventMapping) { mapping = prev }(mapping)
+	mapping, err = newEventMapping([]eventRule{{Event: "session.start", Column: "ssh"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store, err := openStore(path); err == nil {
+		store.Close()
+		t.Fatal("openStore() accepted a store filled under another mapping")
+	}
+	if store, err := openStore(filepath.Join(t.TempDir(), "other.db")); err != nil {
+		t.Errorf("openStore() with a new file: %v", err)
+	} else {
+		store.Close()
+	}
+}